@@ -0,0 +1,152 @@
+// api_key_repo.go
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// apiKeyPrefix starts every API key so that keys can be told apart from JWTs
+// and found by secret scanners.
+const apiKeyPrefix = "hk_"
+
+// APIKey authenticates a reporter without a JWT. Only the SHA-256 of the key
+// is stored; the key itself is shown once, when it is created.
+type APIKey struct {
+	ID           int        `json:"id"`
+	Name         string     `json:"name"`
+	Prefix       string     `json:"prefix"`
+	ReporterName string     `json:"reporterName"`
+	TenantID     string     `json:"tenantId"`
+	Roles        []string   `json:"roles"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastUsedAt   *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty"`
+}
+
+func (k APIKey) principal() *Principal {
+	return &Principal{
+		Subject:  "api-key:" + strconv.Itoa(k.ID),
+		Name:     k.ReporterName,
+		TenantID: k.TenantID,
+		Roles:    k.Roles,
+		Method:   authMethodAPIKey,
+	}
+}
+
+func newAPIKeySecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// hashAPIKey is what is stored for a key. The keys are random, so a fast
+// hash is enough.
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyTouchInterval limits how often the last use of a key is written.
+const apiKeyTouchInterval = time.Minute
+
+const apiKeyColumns = "id, name, key_prefix, reporter_name, tenant_id, roles, created_at, last_used_at, revoked_at"
+
+func scanAPIKey(row rowScanner, key *APIKey) error {
+	return row.Scan(&key.ID, &key.Name, &key.Prefix, &key.ReporterName, &key.TenantID, pq.Array(&key.Roles), &key.CreatedAt, &key.LastUsedAt, &key.RevokedAt)
+}
+
+type APIKeyRepo struct {
+	repo
+}
+
+func NewAPIKeyRepo(db *sql.DB) *APIKeyRepo {
+	return &APIKeyRepo{repo{db: db}}
+}
+
+func (r *APIKeyRepo) WithTx(tx *sql.Tx) *APIKeyRepo {
+	return &APIKeyRepo{repo{db: r.db, tx: tx}}
+}
+
+// Create stores a new key for key.ReporterName and returns the key, which
+// cannot be recovered later.
+func (r *APIKeyRepo) Create(ctx context.Context, key *APIKey) (string, error) {
+	secret, err := newAPIKeySecret()
+	if err != nil {
+		return "", err
+	}
+	key.Prefix = secret[:len(apiKeyPrefix)+8]
+	key.CreatedAt = time.Now()
+	if key.Roles == nil {
+		key.Roles = []string{roleReporter}
+	}
+	err = r.q().QueryRowContext(ctx, "INSERT INTO api_keys(name, key_prefix, key_hash, reporter_name, tenant_id, roles, created_at) VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		key.Name, key.Prefix, hashAPIKey(secret), key.ReporterName, key.TenantID, pq.Array(key.Roles), key.CreatedAt).Scan(&key.ID)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// Authenticate returns the unrevoked key matching secret and records that it
+// was used.
+func (r *APIKeyRepo) Authenticate(ctx context.Context, secret string) (APIKey, error) {
+	var key APIKey
+	err := scanAPIKey(r.q().QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash=$1 AND revoked_at IS NULL", hashAPIKey(secret)), &key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return key, err
+	}
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchInterval {
+		if _, err := r.q().ExecContext(ctx, "UPDATE api_keys SET last_used_at=$1 WHERE id=$2", now, key.ID); err != nil {
+			return key, err
+		}
+		key.LastUsedAt = &now
+	}
+	return key, nil
+}
+
+func (r *APIKeyRepo) List(ctx context.Context) ([]APIKey, error) {
+	rows, err := r.q().QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		if err := scanAPIKey(rows, &key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (r *APIKeyRepo) Revoke(ctx context.Context, id int) error {
+	result, err := r.q().ExecContext(ctx, "UPDATE api_keys SET revoked_at=$1 WHERE id=$2 AND revoked_at IS NULL", time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
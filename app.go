@@ -3,13 +3,18 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -17,35 +22,172 @@ import (
 )
 
 type App struct {
-	Router *mux.Router
-	DB     *sql.DB
+	Logger  *slog.Logger
+	Server  ServerConfig
+	Router  *mux.Router
+	DB      *sql.DB
+	Tracker Tracker
+	Routing *RoutingTable
+	Sync    *SyncWorker
+	Outbox  *OutboxDispatcher
+	// Auth authenticates API requests; nil disables authentication.
+	Auth   *Authenticator
+	Policy *Policy
+	// CORS decides which browser origins may call the API.
+	CORS CORSConfig
+
+	IssueRepo      *IssueRepo
+	StepLogRepo    *StepLogRepo
+	ErrorStoreRepo *ErrorStoreRepo
+	OutboxRepo     *OutboxRepo
+	APIKeyRepo     *APIKeyRepo
+
+	WebhookSecret string
+	// UnknownErrorCodes is what createIssue does with error codes that are
+	// not in error_store: unknownErrorCodesReject or unknownErrorCodesFlag.
+	UnknownErrorCodes string
+	// DedupWindow is how long repeated reports of an issue are counted
+	// against it instead of opening new tickets. Zero disables deduplication.
+	DedupWindow time.Duration
+	// ReadyTimeout bounds each check of GET /readyz, and ReadyCheckTracker
+	// adds the tracker to those checks.
+	ReadyTimeout      time.Duration
+	ReadyCheckTracker bool
+	// RoutingReloadInterval is how often the routing file is checked for
+	// changes.
+	RoutingReloadInterval time.Duration
+	PolicyReloadInterval  time.Duration
 }
 
-func (a *App) Initialize(user, password, dbname string) {
-	connectionString := fmt.Sprintf("user=%s password=%s dbname=%s sslmode=disable", user, password, dbname)
+const (
+	unknownErrorCodesReject = "reject"
+	unknownErrorCodesFlag   = "flag"
+
+	// unknownErrorCodeLabel is added to tracker issues whose error code is
+	// not in the catalog.
+	unknownErrorCodeLabel = "unknown-error-code"
+)
+
+func (a *App) Initialize(connectionString string) error {
+	if a.Logger == nil {
+		a.Logger = slog.Default()
+	}
 	var err error
 	a.DB, err = sql.Open("postgres", connectionString)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.DB.PingContext(ctx); err != nil {
+		return fmt.Errorf("Unable to reach database: [%s]", err.Error())
+	}
+	a.IssueRepo = NewIssueRepo(a.DB)
+	a.StepLogRepo = NewStepLogRepo(a.DB)
+	a.ErrorStoreRepo = NewErrorStoreRepo(a.DB)
+	a.OutboxRepo = NewOutboxRepo(a.DB)
+	a.APIKeyRepo = NewAPIKeyRepo(a.DB)
+	if a.Auth != nil {
+		a.Auth.APIKeys = a.APIKeyRepo
+	}
+	a.Sync = NewSyncWorker(a.IssueRepo, a.Tracker)
+	a.Sync.Logger = a.Logger
+	a.Outbox = NewOutboxDispatcher(a.OutboxRepo, a.IssueRepo, a.Tracker)
+	a.Outbox.Logger = a.Logger
+	a.Outbox.Build = a.trackerIssueFor
+	if a.RoutingReloadInterval == 0 {
+		a.RoutingReloadInterval = routingReloadInterval
+	}
+	if a.PolicyReloadInterval == 0 {
+		a.PolicyReloadInterval = policyReloadInterval
 	}
 	a.Router = mux.NewRouter()
+	a.Router.Use(a.requestIDMiddleware, a.corsMiddleware, a.authMiddleware)
 	a.initializeRoutes()
+	return nil
 }
 
-func (a *App) Run(addr string) {
-	log.Fatal(http.ListenAndServe(addr, a.Router))
+// Run serves the API and runs the background workers until ctx is cancelled
+// or the server fails. It then stops accepting connections, drains in-flight
+// requests for up to Server.ShutdownTimeout, stops the workers and closes
+// the database.
+func (a *App) Run(ctx context.Context) error {
+	workersCtx, stopWorkers := context.WithCancel(withSystemScope(context.Background()))
+	var workers sync.WaitGroup
+	runs := []func(context.Context){
+		func(ctx context.Context) { a.Routing.Watch(ctx, a.RoutingReloadInterval) },
+		a.Sync.Run,
+		a.Outbox.Run,
+	}
+	if a.Policy != nil {
+		runs = append(runs, func(ctx context.Context) { a.Policy.Watch(ctx, a.PolicyReloadInterval) })
+	}
+	for _, run := range runs {
+		workers.Add(1)
+		go func(run func(context.Context)) {
+			defer workers.Done()
+			run(workersCtx)
+		}(run)
+	}
+
+	server := a.Server.httpServer(a.Router)
+	serveErr := make(chan error, 1)
+	go func() {
+		a.Logger.Info("listening", "addr", a.Server.Addr, "tls", a.Server.TLSCertFile != "")
+		serveErr <- a.Server.serve(server)
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		a.Logger.Info("shutting down", "timeout", a.Server.ShutdownTimeout.String())
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), a.Server.ShutdownTimeout)
+	defer cancel()
+	if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+		a.Logger.Error("unable to drain requests", "error", shutdownErr.Error())
+	}
+	stopWorkers()
+	workers.Wait()
+	if closeErr := a.DB.Close(); closeErr != nil {
+		a.Logger.Error("unable to close database", "error", closeErr.Error())
+	}
+	a.Logger.Info("stopped")
+	return err
 }
 
+// issueRefRoute is the path variable of the routes of one issue, which take
+// its Jira id, such as 10042, or its Jira key, such as K8S-123.
+const issueRefRoute = "{issue_jira_id:[a-zA-Z0-9-]+}"
+
 func (a *App) initializeRoutes() {
 	a.Router.HandleFunc("/issue", a.createIssue).Methods("POST")
 	a.Router.HandleFunc("/error", a.createError).Methods("POST")
+	a.Router.HandleFunc("/error", a.listErrors).Methods("GET")
+	a.Router.HandleFunc("/error/import", a.importErrors).Methods("POST")
+	a.Router.HandleFunc("/error/export", a.exportErrors).Methods("GET")
+	a.Router.HandleFunc("/error/{error_code:[A-Za-z0-9_.-]+}", a.getError).Methods("GET")
+	a.Router.HandleFunc("/error/{error_code:[A-Za-z0-9_.-]+}", a.updateError).Methods("PUT")
+	a.Router.HandleFunc("/error/{error_code:[A-Za-z0-9_.-]+}", a.deleteError).Methods("DELETE")
 	a.Router.HandleFunc("/issue/jira", a.createIssueInJira).Methods("POST")
-	a.Router.HandleFunc("/issue/status/{issue_jira_id:[a-zA-Z0-9]+}", a.getStatusIssue).Methods("GET")
-	a.Router.HandleFunc("/job/{issue_jira_id:[a-zA-Z0-9]*}", a.getJob).Methods("GET")
-	a.Router.HandleFunc("/issue/{issue_jira_id:[a-zA-Z0-9]*}", a.deleteIssue).Methods("DELETE")
-	a.Router.HandleFunc("/issue/{issue_jira_id:[a-zA-Z0-9]*}", a.updateIssue).Methods("UPDATE")
+	a.Router.HandleFunc("/routing/test", a.testRouting).Methods("POST")
+	a.Router.HandleFunc("/webhooks/jira", a.jiraWebhook).Methods("POST")
+	a.Router.HandleFunc("/tracker/status", a.getTrackerStatus).Methods("GET")
+	a.Router.HandleFunc("/metrics", a.getMetrics).Methods("GET")
+	a.Router.HandleFunc("/healthz", a.healthz).Methods("GET")
+	a.Router.HandleFunc("/readyz", a.readyz).Methods("GET")
+	a.Router.HandleFunc("/issue/status/"+issueRefRoute, a.getStatusIssue).Methods("GET")
+	a.Router.HandleFunc("/job", a.getJob).Methods("GET")
+	a.Router.HandleFunc("/job/"+issueRefRoute, a.getJob).Methods("GET")
+	a.Router.HandleFunc("/issue/"+issueRefRoute, a.deleteIssue).Methods("DELETE")
+	a.Router.HandleFunc("/issue/"+issueRefRoute, a.updateIssue).Methods("PATCH")
+	a.Router.HandleFunc("/issue/"+issueRefRoute+"/log", a.addStepLog).Methods("POST")
 	a.Router.HandleFunc("/issue", a.getIssue).Methods("GET")
-	a.Router.HandleFunc("/issue/{issue_jira_id:[a-zA-Z0-9]*}", a.GetIssueByJiraID).Methods("GET")
+	a.Router.HandleFunc("/issue/"+issueRefRoute, a.GetIssueByJiraID).Methods("GET")
+	// Lets preflights of every route reach corsMiddleware.
+	a.Router.Methods(http.MethodOptions).HandlerFunc(a.preflight)
 }
 
 func respondWithError(w http.ResponseWriter, code int, message string) {
@@ -59,173 +201,347 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.WriteHeader(code)
 	w.Write(response)
 }
-func enableCors(w *http.ResponseWriter) {
-	(*w).Header().Set("Access-Control-Allow-Origin", "*")
-}
 
+// createIssue stores a report as a pending issue and queues it for the outbox
+// dispatcher to file in Jira, or counts the report against an open issue with
+// the same fingerprint that was seen within DedupWindow and responds with
+// that issue instead.
 func (a *App) createIssue(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
+	if !a.authorize(w, r, actionIssueCreate) {
+		return
+	}
 	var i IssueRequest
 	decoder := json.NewDecoder(r.Body)
-	fmt.Println("Decoding body request creating issue")
 	if err := decoder.Decode(&i); err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 	defer r.Body.Close()
-
-	var projectID string
-	if strings.Contains(i.ErrorCode, "vm_") {
-		projectID = "10000"
-	} else if strings.Contains(i.ErrorCode, "db_") {
-		projectID = "10002"
-	} else if strings.Contains(i.ErrorCode, "k8s_") {
-		projectID = "10001"
-	} else if strings.Contains(i.ErrorCode, "api_") {
-		projectID = "10003"
-	} else {
-		projectID = "10004"
+	if err := i.attributeTo(principalFrom(r.Context())); err != nil {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err := i.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	jiraId, err := PushIssueToProject(projectID, "10004", "xplat", i.ReporterName, i.Content)
+	entry, err := a.lookupErrorCode(r.Context(), i.ErrorCode)
 	if err != nil {
-		fmt.Printf("Unable to create issue in Jira: [%s]\n", err.Error())
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-
-	iDB := Issues{
-		TenantID:    "00001-HN",
-		VpcID:       "12fg5fj4",
-		RegionID:    "HA NOI",
-		IssueJiraID: jiraId,
-		Name:        "K8s Error Network Internal",
-		DataLog:     i.Content,
-		ErrorCode:   i.ErrorCode,
-		Status:      "TO DO",
-		Service:     "K8S",
+	if entry == nil && a.UnknownErrorCodes == unknownErrorCodesReject {
+		respondWithError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Unknown error code %s", i.ErrorCode))
+		return
+	}
+	i = i.withCatalog(entry)
+	if i.Service == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid issue: service is required")
+		return
 	}
 
+	iDB := newIssueFromRequest(i)
+	route := a.Routing.Route(iDB.routeInput())
+	iDB.Assignee = route.Assignee
+	iDB.Priority = route.Priority
 	iDB.CreatedAt = time.Now()
 	iDB.UpdatedAt = time.Now()
-	if err := iDB.createIssue(a.DB); err != nil {
-		fmt.Println("Creating issue")
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+	outboxEntry := OutboxEntry{ReporterName: i.ReporterName}
+	stepLog := StepLog{
+		ReporterName:  "xplat",
+		SupporterName: "xplat",
+		Description:   i.Content,
+		Status:        "to do",
+	}
+	var existing Issues
+	recorded := false
+	if a.DedupWindow > 0 {
+		existing, recorded, err = a.OutboxRepo.EnqueueOrRecord(r.Context(), &iDB, &outboxEntry, stepLog, time.Now().Add(-a.DedupWindow))
+	} else {
+		err = a.OutboxRepo.Enqueue(r.Context(), &iDB, &outboxEntry, stepLog)
+	}
+	if err != nil {
+		a.Logger.ErrorContext(r.Context(), "unable to store issue", "error", err.Error())
+		respondWithError(w, issueErrorStatus(err), err.Error())
+		return
+	}
+	if recorded {
+		a.Logger.InfoContext(r.Context(), "counted repeated report", "issue_id", existing.ID, "occurrences", existing.OccurrenceCount)
+		respondWithJSON(w, http.StatusOK, existing)
 		return
 	}
+	a.Outbox.Notify()
 
-	// a.UpdateIssueJiraIdInDB(a.DB, jiraId)
+	a.Logger.InfoContext(r.Context(), "queued issue for Jira", "issue_id", iDB.ID, "error_code", iDB.ErrorCode, "tenant_id", iDB.TenantID)
+	respondWithJSON(w, http.StatusAccepted, iDB)
+}
+
+// newIssueFromRequest builds the issues row for a client report.
+func newIssueFromRequest(i IssueRequest) Issues {
+	name := i.Name
+	if name == "" {
+		name = i.ErrorCode
+	}
+	return Issues{
+		TenantID:  i.TenantID,
+		VpcID:     i.VpcID,
+		RegionID:  i.RegionID,
+		Name:      name,
+		DataLog:   i.Content,
+		ErrorCode: i.ErrorCode,
+		Status:    "TO DO",
+		Service:   i.Service,
+
+		Fingerprint: issueFingerprint(i),
+	}
+}
 
-	err1 := AddStepLog(a.DB, jiraId, "xplat", "xplat", i.Content, "to do", time.Now(), time.Now())
+// newTrackerIssue builds the tracker issue for i. The description starts
+// with the catalog description of the error code; when entry is nil the
+// issue is labelled unknownErrorCodeLabel instead.
+func newTrackerIssue(i IssueRequest, route Route, entry *ErrorStore) TrackerIssue {
+	labels := append([]string(nil), route.Labels...)
+	description := i.Content
+	if entry == nil {
+		labels = append(labels, unknownErrorCodeLabel)
+	} else if entry.Description != "" {
+		description = entry.Description + "\n\n" + i.Content
+	}
+	return TrackerIssue{
+		ProjectID:   route.ProjectID,
+		IssueType:   route.IssueType,
+		Assignee:    route.Assignee,
+		Priority:    route.Priority,
+		Labels:      labels,
+		Reporter:    i.ReporterName,
+		Summary:     summarize(i.Content),
+		Description: description,
+		Environment: fmt.Sprintf("tenant %s, vpc %s, region %s, service %s", i.TenantID, i.VpcID, i.RegionID, i.Service),
+		RoutingRule: route.Rule,
+	}
+}
 
-	if err1 != nil {
-		fmt.Printf("Unable to add  step log to DB: [%s]\n", err.Error())
-		respondWithError(w, http.StatusInternalServerError, err.Error())
-		return
+// trackerIssueFor builds the tracker issue for a stored issue, keeping the
+// assignee and priority it was routed to when it was created.
+func (a *App) trackerIssueFor(ctx context.Context, issue Issues, reporterName string) (TrackerIssue, error) {
+	entry, err := a.lookupErrorCode(ctx, issue.ErrorCode)
+	if err != nil {
+		return TrackerIssue{}, err
+	}
+	route := a.Routing.Route(issue.routeInput())
+	if issue.Assignee != "" {
+		route.Assignee = issue.Assignee
 	}
+	if issue.Priority != "" {
+		route.Priority = issue.Priority
+	}
+	return newTrackerIssue(issue.request(reporterName), route, entry), nil
+}
 
-	respondWithJSON(w, http.StatusCreated, i)
-	fmt.Println("Created issue successfully")
+// lookupErrorCode returns the catalog entry for errorCode, or nil when the
+// code is not in the catalog.
+func (a *App) lookupErrorCode(ctx context.Context, errorCode string) (*ErrorStore, error) {
+	entry, err := a.ErrorStoreRepo.Get(ctx, errorCode)
+	if errors.Is(err, ErrErrorCodeNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
 }
 
+// createIssueInJira queues an issue that is stored locally but has no Jira
+// ticket, such as one Jira rejected, for the outbox dispatcher to file again.
 func (a *App) createIssueInJira(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
-	var i IssueRequest
+	if !a.authorize(w, r, actionIssuePush) {
+		return
+	}
+	var p PushIssueRequest
 	decoder := json.NewDecoder(r.Body)
-	fmt.Println("Decoding body request creating issue")
-	if err := decoder.Decode(&i); err != nil {
+	if err := decoder.Decode(&p); err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 	defer r.Body.Close()
-	var projectID string
-	if strings.Contains(i.ErrorCode, "vm_") {
-		projectID = "10000"
-	} else if strings.Contains(i.ErrorCode, "db_") {
-		projectID = "10002"
-	} else if strings.Contains(i.ErrorCode, "k8s_") {
-		projectID = "10001"
-	} else if strings.Contains(i.ErrorCode, "api_") {
-		projectID = "10003"
-	} else {
-		projectID = "10004"
-	}
 
-	jiraId, err := PushIssueToProject(projectID, "10004", "xplat", i.ReporterName, i.Content)
-	if err != nil {
-		fmt.Printf("Unable to create issue in Jira: [%s]\n", err.Error())
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+	if principal := principalFrom(r.Context()); principal != nil {
+		p.ReporterName = principal.Name
+	}
+	actor := actorName(r.Context(), "api")
+	issue := Issues{}
+	issue.ID = p.ID
+	err := a.OutboxRepo.Requeue(r.Context(), &issue, &OutboxEntry{ReporterName: p.ReporterName}, StepLog{
+		ReporterName:  actor,
+		SupporterName: actor,
+		Description:   "Queued for Jira again",
+	})
+	switch {
+	case errors.Is(err, ErrIssueNotFound):
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("Issue %d does not exist", p.ID))
+		return
+	case errors.Is(err, ErrIssueInJira):
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Issue %d is already in Jira as %s", issue.ID, issue.IssueJiraID))
+		return
+	case errors.Is(err, ErrIssueQueued):
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Issue %d is already queued for Jira", issue.ID))
+		return
+	case err != nil:
+		a.Logger.ErrorContext(r.Context(), "unable to queue issue for Jira", "issue_id", p.ID, "error", err.Error())
+		respondWithError(w, issueErrorStatus(err), err.Error())
 		return
 	}
+	a.Outbox.Notify()
 
-	a.UpdateIssueJiraIdInDB(a.DB, jiraId)
+	a.Logger.InfoContext(r.Context(), "queued issue for Jira again", "issue_id", issue.ID)
+	respondWithJSON(w, http.StatusAccepted, issue)
+}
 
-	err1 := AddStepLog(a.DB, jiraId, "xplat", "xplat", i.Content, "to do", time.Now(), time.Now())
+func (a *App) testRouting(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, actionRoutingTest) {
+		return
+	}
+	var i IssueRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&i); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	respondWithJSON(w, http.StatusOK, a.Routing.Route(newIssueFromRequest(i).routeInput()))
+}
 
-	if err1 != nil {
-		fmt.Printf("Unable to add  step log to DB: [%s]\n", err.Error())
+func (a *App) createError(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, actionErrorWrite) {
+		return
+	}
+	var e ErrorStore
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&e); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	if err := e.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	e.CreatedAt = time.Now()
+	e.UpdatedAt = time.Now()
+	if err := a.ErrorStoreRepo.Create(r.Context(), &e); err != nil {
+		if errors.Is(err, ErrErrorCodeExists) {
+			respondWithError(w, http.StatusConflict, fmt.Sprintf("Error code %s already exists", e.ErrorCode))
+			return
+		}
+		a.Logger.ErrorContext(r.Context(), "unable to create error code", "error_code", e.ErrorCode, "error", err.Error())
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	a.Logger.InfoContext(r.Context(), "created error code", "error_code", e.ErrorCode)
+	respondWithJSON(w, http.StatusCreated, e)
 }
 
-func PushIssueToBacklogJira() error {
-	return nil
+func (a *App) listErrors(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, actionErrorRead) {
+		return
+	}
+	entries, err := a.ErrorStoreRepo.List(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, entries)
 }
 
-func PushIssueToProject(projectID, issueType, assignee, reporter, content string) (string, error) {
-	url := fmt.Sprintf("http://10.0.0.10:8000/issue/?project_id=%s&issuetype=%s&assignee=%s&reporter=%s&content=%s&summary=%s&environment=environment", projectID, issueType, assignee, reporter, content, content)
-	fmt.Println("url is: ", url)
-	method := "POST"
-	payload := strings.NewReader(``)
-
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, payload)
-
+// importErrors loads an error catalog in YAML or CSV, picked by ?format= or
+// the Content-Type. With ?dry_run=true it only reports what would change.
+func (a *App) importErrors(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, actionErrorWrite) {
+		return
+	}
+	format, err := catalogFormat(r.URL.Query().Get("format"), r.Header.Get("Content-Type"))
 	if err != nil {
-		fmt.Println(err)
-		return "", err
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	req.Header.Add("Content-type", "application/json")
-
-	res, err := client.Do(req)
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxCatalogImportSize))
 	if err != nil {
-		fmt.Printf("Unable to perform request: [%s]", err.Error())
-		fmt.Println(err)
-		return "", err
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
 	}
-	defer res.Body.Close()
-
-	body, err := ioutil.ReadAll(res.Body)
+	defer r.Body.Close()
+	catalog, err := parseCatalog(format, data)
 	if err != nil {
-		fmt.Println(err)
-		fmt.Printf("Unable to read response body: [%s]", err.Error())
-		return "", err
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	fmt.Print(body)
-	// dataBody := []byte(body)
-	// var responseJira ResponseJira
-	// err = json.Unmarshal(dataBody, &responseJira)
-
-	// out, _ := json.Marshal(body)
-
-	// if err != nil {
-	// 	// if error is not nil
-	// 	// print error
-	// 	fmt.Println("looix roi")
-	// }
-
-	// // fmt.Sprintf(responseJira.Id)
-	// fmt.Print(out.Id)
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	report, err := a.ErrorStoreRepo.Import(r.Context(), catalog, dryRun)
+	if err != nil {
+		a.Logger.ErrorContext(r.Context(), "unable to import error catalog", "error", err.Error())
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, report)
+}
 
-	fmt.Print(string(body))
+// exportErrors writes the whole error catalog in YAML or CSV, picked by
+// ?format= or the Accept header, in the format importErrors reads.
+func (a *App) exportErrors(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, actionErrorRead) {
+		return
+	}
+	format, err := catalogFormat(r.URL.Query().Get("format"), r.Header.Get("Accept"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	catalog, err := a.ErrorStoreRepo.List(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var body bytes.Buffer
+	if err := writeCatalog(&body, format, catalog); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	contentType := "application/yaml"
+	if format == catalogFormatCSV {
+		contentType = "text/csv"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"error_catalog.%s\"", format))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
 
-	return string(body)[7:12], nil
+func (a *App) getError(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, actionErrorRead) {
+		return
+	}
+	errorCode := mux.Vars(r)["error_code"]
+	entry, err := a.ErrorStoreRepo.Get(r.Context(), errorCode)
+	if err != nil {
+		if errors.Is(err, ErrErrorCodeNotFound) {
+			respondWithError(w, http.StatusNotFound, fmt.Sprintf("Error code %s does not exist", errorCode))
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, entry)
 }
 
-func (a *App) createError(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
+// updateError replaces the definition of an error code. The error code in
+// the path wins over the one in the body.
+func (a *App) updateError(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, actionErrorWrite) {
+		return
+	}
+	errorCode := mux.Vars(r)["error_code"]
 	var e ErrorStore
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&e); err != nil {
@@ -233,102 +549,270 @@ func (a *App) createError(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer r.Body.Close()
-	e.CreatedAt = time.Now()
-	e.UpdatedAt = time.Now()
-	if err := e.createError(a.DB); err != nil {
-		fmt.Println("Creating error store")
+	e.ErrorCode = errorCode
+	if err := e.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := a.ErrorStoreRepo.Update(r.Context(), &e); err != nil {
+		if errors.Is(err, ErrErrorCodeNotFound) {
+			respondWithError(w, http.StatusNotFound, fmt.Sprintf("Error code %s does not exist", errorCode))
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	fmt.Println("Created error store successfully")
-	respondWithJSON(w, http.StatusCreated, e)
+	respondWithJSON(w, http.StatusOK, e)
+}
+
+func (a *App) deleteError(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, actionErrorWrite) {
+		return
+	}
+	errorCode := mux.Vars(r)["error_code"]
+	if err := a.ErrorStoreRepo.Delete(r.Context(), errorCode); err != nil {
+		if errors.Is(err, ErrErrorCodeNotFound) {
+			respondWithError(w, http.StatusNotFound, fmt.Sprintf("Error code %s does not exist", errorCode))
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]string{"delete": "success"})
 }
 
 func (a *App) getStatusIssue(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
+	if !a.authorize(w, r, actionIssueRead) {
+		return
+	}
 	vars := mux.Vars(r)
 	issueJiraID := vars["issue_jira_id"]
-	fmt.Println("issue_jira_id is: ", issueJiraID)
-	issue := Issues{
-		IssueJiraID: issueJiraID,
-	}
-	err := issue.getStatusIssue(a.DB, issueJiraID)
+	status, err := a.IssueRepo.GetStatus(r.Context(), issueJiraID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		if errors.Is(err, ErrIssueNotFound) {
+			respondWithError(w, http.StatusNotFound, fmt.Sprintf("Issue %s does not exist", issueJiraID))
+			return
+		}
+		respondWithError(w, issueErrorStatus(err), err.Error())
 		return
 	}
-	respondWithJSON(w, http.StatusOK, issue.Status)
+	respondWithJSON(w, http.StatusOK, status)
 }
 
+// getJob reports what the sync worker knows about the issues in the
+// caller's tenant scope, or about one of them.
 func (a *App) getJob(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
+	if !a.authorize(w, r, actionJobRead) {
+		return
+	}
+	scope, err := tenantScopeFrom(r.Context())
+	if err != nil {
+		respondWithError(w, issueErrorStatus(err), err.Error())
+		return
+	}
 	vars := mux.Vars(r)
 	issueJiraID := vars["issue_jira_id"]
-	issue := Issues{
-		IssueJiraID: issueJiraID,
+	if issueJiraID == "" {
+		entries := []SyncEntry{}
+		var issues []Issues
+		for _, entry := range a.Sync.Registry.List() {
+			if scope.check(entry.TenantID) == nil {
+				entries = append(entries, entry)
+				issues = append(issues, entry.issue())
+			}
+		}
+		scope.audit(r.Context(), "job.list", issues...)
+		respondWithJSON(w, http.StatusOK, entries)
+		return
+	}
+	entry, ok := a.Sync.Registry.Get(issueJiraID)
+	if !ok || scope.check(entry.TenantID) != nil {
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("Issue %s is not being tracked", issueJiraID))
+		return
 	}
-	issue.ApiJob(a.DB, issueJiraID)
+	scope.audit(r.Context(), "job.get", entry.issue())
+	respondWithJSON(w, http.StatusOK, entry)
+}
 
-	respondWithJSON(w, http.StatusOK, "")
+func (a *App) getTrackerStatus(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, actionTrackerStatus) {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, a.Tracker.Health())
 }
 
 func (a *App) deleteIssue(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
+	if !a.authorize(w, r, actionIssueDelete) {
+		return
+	}
 	vars := mux.Vars(r)
 	issueJiraID := vars["issue_jira_id"]
-	issue := Issues{
-		IssueJiraID: issueJiraID,
-	}
-	if err := issue.DeleteIssue(a.DB, issueJiraID); err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+	if err := a.IssueRepo.Delete(r.Context(), issueJiraID); err != nil {
+		if errors.Is(err, ErrIssueNotFound) {
+			respondWithError(w, http.StatusNotFound, fmt.Sprintf("Issue %s does not exist", issueJiraID))
+			return
+		}
+		respondWithError(w, issueErrorStatus(err), err.Error())
 		return
 	}
 	respondWithJSON(w, http.StatusOK, map[string]string{"delete": "success"})
 }
 
+// updateIssue applies a partial update to an issue. A status change to an
+// issue that is in Jira is made in the tracker first; otherwise the sync
+// worker would undo it with Jira's status on its next pass.
 func (a *App) updateIssue(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
+	if !a.authorize(w, r, actionIssueUpdate) {
+		return
+	}
 	vars := mux.Vars(r)
 	issueJiraID := vars["issue_jira_id"]
-	status := vars["status"]
-	issue := Issues{
-		IssueJiraID: issueJiraID,
+	var update IssueUpdateRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&update); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
 	}
-	if err := issue.UpdateIssueStatusInDB(a.DB, status); err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+	defer r.Body.Close()
+	if err := update.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	issue, err := a.IssueRepo.GetByJiraRef(r.Context(), issueJiraID, issueJiraID)
+	if err != nil {
+		if errors.Is(err, ErrIssueNotFound) {
+			respondWithError(w, http.StatusNotFound, fmt.Sprintf("Issue %s does not exist", issueJiraID))
+			return
+		}
+		respondWithError(w, issueErrorStatus(err), err.Error())
+		return
+	}
+
+	if update.Status != nil && issue.IssueJiraID != "" && !strings.EqualFold(*update.Status, issue.Status) {
+		if err := checkTransition(issue.Status, *update.Status); err != nil {
+			respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err := a.Tracker.Transition(r.Context(), issue.IssueJiraID, *update.Status); err != nil {
+			a.Logger.ErrorContext(r.Context(), "unable to transition issue in Jira", "issue_jira_id", issue.IssueJiraID, "status", *update.Status, "error", err.Error())
+			respondWithError(w, trackerHTTPStatus(err), err.Error())
+			return
+		}
+	}
+
+	if err := a.IssueRepo.UpdateFields(r.Context(), &issue, update, actorName(r.Context(), "api")); err != nil {
+		var transitionErr *TransitionError
+		switch {
+		case errors.Is(err, ErrIssueNotFound):
+			respondWithError(w, http.StatusNotFound, err.Error())
+		case errors.As(err, &transitionErr):
+			respondWithError(w, http.StatusConflict, err.Error())
+		default:
+			respondWithError(w, issueErrorStatus(err), err.Error())
+		}
 		return
 	}
 	respondWithJSON(w, http.StatusOK, issue)
 }
 
+// getIssue lists issues one page at a time. See parseIssueFilter for the
+// supported query parameters; the total number of matches is returned in
+// X-Total-Count and the next page's cursor in X-Next-Cursor and Link.
 func (a *App) getIssue(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
-	issue, err := a.GetIssue(a.DB)
+	if !a.authorize(w, r, actionIssueRead) {
+		return
+	}
+	filter, err := parseIssueFilter(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, err := a.IssueRepo.ListPage(r.Context(), filter)
 	if err != nil {
+		respondWithError(w, issueErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor != "" {
+		next := *r.URL
+		query := next.Query()
+		query.Set("cursor", page.NextCursor)
+		next.RawQuery = query.Encode()
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.String()))
+	}
+	respondWithJSON(w, http.StatusOK, page.Issues)
+}
+
+// addStepLog appends a note to the history of an issue without changing
+// its status.
+func (a *App) addStepLog(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, actionIssueLog) {
+		return
+	}
+	issueJiraID := mux.Vars(r)["issue_jira_id"]
+	var l StepLogRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&l); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	if err := l.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	issue, err := a.IssueRepo.GetByJiraRef(r.Context(), issueJiraID, issueJiraID)
+	if err != nil {
+		if errors.Is(err, ErrIssueNotFound) {
+			respondWithError(w, http.StatusNotFound, fmt.Sprintf("Issue %s does not exist", issueJiraID))
+			return
+		}
+		respondWithError(w, issueErrorStatus(err), err.Error())
+		return
+	}
+	actor := actorName(r.Context(), "api")
+	stepLog := StepLog{
+		IssueID:       issue.stepLogIssueID(),
+		ReporterName:  actor,
+		SupporterName: actor,
+		SupporterJira: l.SupporterJira,
+		Description:   l.Description,
+		Status:        issue.Status,
+	}
+	if err := a.StepLogRepo.Add(r.Context(), &stepLog); err != nil {
+		a.Logger.ErrorContext(r.Context(), "unable to add step log", "issue_id", issue.ID, "error", err.Error())
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	respondWithJSON(w, http.StatusOK, issue)
+	respondWithJSON(w, http.StatusCreated, stepLog)
 }
 
 func (a *App) GetIssueByJiraID(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
+	if !a.authorize(w, r, actionIssueRead) {
+		return
+	}
 	vars := mux.Vars(r)
 	issueJiraID := vars["issue_jira_id"]
 
-	fmt.Print(issueJiraID)
-
-	issue := Issues{
-		IssueJiraID: issueJiraID,
+	issue, err := a.IssueRepo.GetByJiraRef(r.Context(), issueJiraID, issueJiraID)
+	if err != nil {
+		if errors.Is(err, ErrIssueNotFound) {
+			respondWithError(w, http.StatusNotFound, fmt.Sprintf("Issue %s does not exist", issueJiraID))
+			return
+		}
+		respondWithError(w, issueErrorStatus(err), err.Error())
+		return
 	}
 
-	if err := issue.GetIssueByJiraID(a.DB, issueJiraID); err != nil {
+	logs, err := a.StepLogRepo.ListByIssueJiraID(r.Context(), issue.IssueJiraID)
+	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	logs, _ := a.GetLogsByIssueJiraId(a.DB, issueJiraID)
-
 	i := IssuesReturn{
 		Issue: issue,
 		Logs:  logs,
@@ -0,0 +1,191 @@
+// auth.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnauthenticated is wrapped by every error caused by missing or invalid
+// credentials, as opposed to failures looking them up.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+const (
+	authMethodAPIKey = "api_key"
+	authMethodJWT    = "jwt"
+)
+
+// Principal is who a request was authenticated as. Name is recorded as the
+// reporter of the issues they report; a principal with a TenantID may only
+// report issues for that tenant. Roles are checked against the Policy.
+type Principal struct {
+	Subject  string   `json:"subject"`
+	Name     string   `json:"name"`
+	TenantID string   `json:"tenantId,omitempty"`
+	Roles    []string `json:"roles"`
+	Method   string   `json:"method"`
+}
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principalFrom returns nil when authentication is disabled or the route is
+// public.
+func principalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// actorName is the name of the principal of ctx, or fallback when there is
+// none.
+func actorName(ctx context.Context, fallback string) string {
+	if p := principalFrom(ctx); p != nil && p.Name != "" {
+		return p.Name
+	}
+	return fallback
+}
+
+// publicRoutes are served without credentials. The Jira webhook checks its
+// own secret.
+var publicRoutes = map[string]bool{
+	"/healthz":       true,
+	"/readyz":        true,
+	"/webhooks/jira": true,
+}
+
+// Authenticator accepts API keys, given as a bearer token or in X-API-Key,
+// and JWT bearer tokens when JWT is set.
+type Authenticator struct {
+	APIKeys *APIKeyRepo
+	JWT     *JWTVerifier
+}
+
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token := r.Header.Get("X-API-Key")
+	if authorization := r.Header.Get("Authorization"); token == "" && authorization != "" {
+		scheme, credentials, _ := strings.Cut(authorization, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return nil, fmt.Errorf("%w: unsupported authorization scheme %q", ErrUnauthenticated, scheme)
+		}
+		token = strings.TrimSpace(credentials)
+	}
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("%w: missing credentials", ErrUnauthenticated)
+	case strings.HasPrefix(token, apiKeyPrefix):
+		key, err := a.APIKeys.Authenticate(r.Context(), token)
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			return nil, fmt.Errorf("%w: unknown or revoked API key", ErrUnauthenticated)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return key.principal(), nil
+	case a.JWT == nil:
+		return nil, fmt.Errorf("%w: bearer tokens other than API keys are not accepted", ErrUnauthenticated)
+	default:
+		return a.JWT.Verify(token, time.Now())
+	}
+}
+
+// authMiddleware rejects requests to non-public routes that do not carry
+// valid credentials and puts the principal in the request context. When
+// a.Auth is nil every request acts for the system, across tenants.
+func (a *App) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.Auth == nil {
+			// Without authentication there is no tenant to scope requests to.
+			next.ServeHTTP(w, r.WithContext(withSystemScope(r.Context())))
+			return
+		}
+		if r.Method == http.MethodOptions || publicRoutes[routeTemplate(r)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		principal, err := a.Auth.Authenticate(r)
+		if err != nil {
+			if !errors.Is(err, ErrUnauthenticated) {
+				a.Logger.ErrorContext(r.Context(), "unable to authenticate request", "error", err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Unable to authenticate request")
+				return
+			}
+			a.Logger.WarnContext(r.Context(), "rejected unauthenticated request", "route", routeTemplate(r), "error", err.Error())
+			w.Header().Set("WWW-Authenticate", `Bearer realm="hickathon"`)
+			respondWithError(w, http.StatusUnauthorized, strings.TrimPrefix(err.Error(), ErrUnauthenticated.Error()+": "))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), principal)))
+	})
+}
+
+// runAPIKeyCommand implements "apikey create|list|revoke".
+func runAPIKeyCommand(w io.Writer, keys *APIKeyRepo, args []string) error {
+	usage := fmt.Errorf("usage: apikey create -name NAME -reporter REPORTER [-tenant TENANT] [-roles ROLE,...] | list | revoke ID")
+	if len(args) == 0 {
+		return usage
+	}
+	ctx := context.Background()
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+		var key APIKey
+		flags.StringVar(&key.Name, "name", "", "what the key is for")
+		flags.StringVar(&key.ReporterName, "reporter", "", "reporter name of the issues reported with the key")
+		flags.StringVar(&key.TenantID, "tenant", "", "only allow issues for this tenant")
+		roles := flags.String("roles", roleReporter, "comma separated roles of the key")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		key.Roles = splitRoles(*roles)
+		if key.Name == "" || key.ReporterName == "" {
+			return usage
+		}
+		secret, err := keys.Create(ctx, &key)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Created API key %d (%s) for %s with roles %s. It will not be shown again:\n%s\n",
+			key.ID, key.Name, key.ReporterName, strings.Join(key.Roles, ", "), secret)
+		return nil
+	case "list":
+		list, err := keys.List(ctx)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(list)
+	case "revoke":
+		if len(args) != 2 {
+			return usage
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("Invalid API key id: %s", args[1])
+		}
+		if err := keys.Revoke(ctx, id); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Revoked API key %d\n", id)
+		return nil
+	default:
+		return usage
+	}
+}
+
+// splitRoles reads roles separated by commas or spaces.
+func splitRoles(roles string) []string {
+	return strings.FieldsFunc(roles, func(r rune) bool { return r == ',' || r == ' ' })
+}
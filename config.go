@@ -0,0 +1,445 @@
+// config.go
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the service. Values are read from the YAML
+// file given by -config or APP_CONFIG, then overridden by the APP_* variable
+// named in each field's env tag, then by the flag named after the field's
+// YAML path, e.g. -database.host.
+type Config struct {
+	LogLevel    string         `yaml:"logLevel" env:"APP_LOG_LEVEL"`
+	Server      ServerConfig   `yaml:"server"`
+	Database    DatabaseConfig `yaml:"database"`
+	Tracker     TrackerConfig  `yaml:"tracker"`
+	RoutingFile string         `yaml:"routingFile" env:"APP_ROUTING_FILE"`
+	Issues      IssuesConfig   `yaml:"issues"`
+	Workers     WorkersConfig  `yaml:"workers"`
+	Ready       ReadyConfig    `yaml:"ready"`
+	Auth        AuthConfig     `yaml:"auth"`
+	CORS        CORSConfig     `yaml:"cors"`
+}
+
+// DatabaseConfig selects the Postgres database. When DSN is set, either as
+// key=value pairs or as a postgres:// URL, the other fields are ignored.
+type DatabaseConfig struct {
+	DSN      string `yaml:"dsn" env:"APP_DB_DSN" secret:"dsn"`
+	Host     string `yaml:"host" env:"APP_DB_HOST"`
+	Port     int    `yaml:"port" env:"APP_DB_PORT"`
+	User     string `yaml:"user" env:"APP_DB_USERNAME"`
+	Password string `yaml:"password" env:"APP_DB_PASSWORD" secret:"true"`
+	Name     string `yaml:"name" env:"APP_DB_NAME"`
+	SSLMode  string `yaml:"sslMode" env:"APP_DB_SSLMODE"`
+}
+
+type TrackerConfig struct {
+	// Kind is "jira" or "memory".
+	Kind string     `yaml:"kind" env:"APP_TRACKER"`
+	Jira JiraConfig `yaml:"jira"`
+}
+
+type JiraConfig struct {
+	URL           string `yaml:"url" env:"APP_JIRA_URL"`
+	Username      string `yaml:"username" env:"APP_JIRA_USERNAME"`
+	Token         string `yaml:"token" env:"APP_JIRA_TOKEN" secret:"true"`
+	CloseStatus   string `yaml:"closeStatus" env:"APP_JIRA_CLOSE_STATUS"`
+	WebhookSecret string `yaml:"webhookSecret" env:"APP_JIRA_WEBHOOK_SECRET" secret:"true"`
+}
+
+type IssuesConfig struct {
+	UnknownErrorCodes string        `yaml:"unknownErrorCodes" env:"APP_UNKNOWN_ERROR_CODES"`
+	DedupWindow       time.Duration `yaml:"dedupWindow" env:"APP_DEDUP_WINDOW"`
+}
+
+type WorkersConfig struct {
+	SyncInterval          time.Duration `yaml:"syncInterval" env:"APP_SYNC_INTERVAL"`
+	OutboxInterval        time.Duration `yaml:"outboxInterval" env:"APP_OUTBOX_INTERVAL"`
+	RoutingReloadInterval time.Duration `yaml:"routingReloadInterval" env:"APP_ROUTING_RELOAD_INTERVAL"`
+	PolicyReloadInterval  time.Duration `yaml:"policyReloadInterval" env:"APP_POLICY_RELOAD_INTERVAL"`
+}
+
+type ReadyConfig struct {
+	Timeout      time.Duration `yaml:"timeout" env:"APP_READY_TIMEOUT"`
+	CheckTracker bool          `yaml:"checkTracker" env:"APP_READY_CHECK_TRACKER"`
+}
+
+// AuthConfig controls authentication of the API. API keys are always
+// accepted when it is enabled; JWTs only when JWT has a secret or JWKS file.
+type AuthConfig struct {
+	Enabled bool      `yaml:"enabled" env:"APP_AUTH_ENABLED"`
+	JWT     JWTConfig `yaml:"jwt"`
+	// PolicyFile grants actions to roles; see policy.example.yaml. The
+	// built-in policy is used when it is empty.
+	PolicyFile string `yaml:"policyFile" env:"APP_POLICY_FILE"`
+}
+
+type JWTConfig struct {
+	Issuer      string `yaml:"issuer" env:"APP_JWT_ISSUER"`
+	Audience    string `yaml:"audience" env:"APP_JWT_AUDIENCE"`
+	HMACSecret  string `yaml:"hmacSecret" env:"APP_JWT_HMAC_SECRET" secret:"true"`
+	JWKSFile    string `yaml:"jwksFile" env:"APP_JWT_JWKS_FILE"`
+	TenantClaim string `yaml:"tenantClaim" env:"APP_JWT_TENANT_CLAIM"`
+	RolesClaim  string `yaml:"rolesClaim" env:"APP_JWT_ROLES_CLAIM"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		LogLevel: "info",
+		Server:   defaultServerConfig(),
+		Database: DatabaseConfig{SSLMode: "disable"},
+		Tracker: TrackerConfig{
+			Kind: "jira",
+			Jira: JiraConfig{CloseStatus: "Done"},
+		},
+		Issues: IssuesConfig{DedupWindow: defaultDedupWindow},
+		Workers: WorkersConfig{
+			SyncInterval:          30 * time.Second,
+			OutboxInterval:        5 * time.Second,
+			RoutingReloadInterval: routingReloadInterval,
+			PolicyReloadInterval:  policyReloadInterval,
+		},
+		Ready: ReadyConfig{Timeout: defaultReadyTimeout},
+		Auth: AuthConfig{
+			Enabled: true,
+			JWT:     JWTConfig{TenantClaim: "tenant_id", RolesClaim: "roles"},
+		},
+		CORS: defaultCORSConfig(),
+	}
+}
+
+// LoadConfig reads the configuration from the file, lookupEnv and the flags
+// in args, in that order of precedence. It returns the arguments left after
+// the flags.
+func LoadConfig(args []string, lookupEnv func(string) (string, bool)) (Config, []string, error) {
+	config := defaultConfig()
+	fields := config.fields()
+
+	flags := flag.NewFlagSet("hickathon", flag.ContinueOnError)
+	path := flags.String("config", "", "YAML configuration file (APP_CONFIG)")
+	for _, field := range fields {
+		flags.Var(&rawFlag{bool: field.value.Kind() == reflect.Bool}, field.name, "overrides "+field.env)
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: hickathon [flags] [migrate up|down [steps]|status | apikey create|list|revoke | config print]\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return config, nil, err
+	}
+
+	if *path == "" {
+		*path, _ = lookupEnv("APP_CONFIG")
+	}
+	if *path != "" {
+		if err := config.readFile(*path); err != nil {
+			return config, nil, err
+		}
+	}
+	for _, field := range fields {
+		if value, ok := lookupEnv(field.env); ok && field.env != "" {
+			if err := field.set(value); err != nil {
+				return config, nil, fmt.Errorf("Invalid %s: %w", field.env, err)
+			}
+		}
+	}
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		if field, ok := configFieldNamed(fields, f.Name); ok && err == nil {
+			if setErr := field.set(f.Value.String()); setErr != nil {
+				err = fmt.Errorf("Invalid -%s: %w", f.Name, setErr)
+			}
+		}
+	})
+	return config, flags.Args(), err
+}
+
+func (c *Config) readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Unable to read config file: [%s]", err.Error())
+	}
+	defer file.Close()
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("Invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every problem with the configuration at once.
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.LogLevel)) == nil, "logLevel must be debug, info, warn or error")
+
+	check(c.Server.Addr != "", "server.addr is required")
+	check((c.Server.TLSCertFile == "") == (c.Server.TLSKeyFile == ""), "server.tlsCertFile and server.tlsKeyFile must be set together")
+	check(c.Server.ReadTimeout >= 0 && c.Server.ReadHeaderTimeout >= 0 && c.Server.WriteTimeout >= 0 && c.Server.IdleTimeout >= 0,
+		"server timeouts must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdownTimeout must be positive")
+
+	if c.Database.DSN == "" {
+		check(c.Database.Port >= 0 && c.Database.Port <= 65535, "database.port must be between 1 and 65535")
+		switch c.Database.SSLMode {
+		case "", "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+		default:
+			check(false, "database.sslMode must be disable, allow, prefer, require, verify-ca or verify-full")
+		}
+	}
+
+	switch c.Tracker.Kind {
+	case "memory":
+	case "jira":
+		if c.Tracker.Jira.URL == "" {
+			check(false, "tracker.jira.url is required")
+		} else {
+			u, err := url.Parse(c.Tracker.Jira.URL)
+			check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "tracker.jira.url must be an http or https URL")
+		}
+		check(c.Tracker.Jira.CloseStatus != "", "tracker.jira.closeStatus is required")
+	default:
+		check(false, "tracker.kind must be jira or memory")
+	}
+
+	switch c.Issues.UnknownErrorCodes {
+	case "", unknownErrorCodesFlag, unknownErrorCodesReject:
+	default:
+		check(false, "issues.unknownErrorCodes must be %q or %q", unknownErrorCodesFlag, unknownErrorCodesReject)
+	}
+	check(c.Issues.DedupWindow >= 0, "issues.dedupWindow must not be negative; use 0 to disable deduplication")
+
+	check(c.Workers.SyncInterval > 0, "workers.syncInterval must be positive")
+	check(c.Workers.OutboxInterval > 0, "workers.outboxInterval must be positive")
+	check(c.Workers.RoutingReloadInterval > 0, "workers.routingReloadInterval must be positive")
+	check(c.Workers.PolicyReloadInterval > 0, "workers.policyReloadInterval must be positive")
+	check(c.Ready.Timeout > 0, "ready.timeout must be positive")
+
+	if jwt := c.Auth.JWT; c.Auth.Enabled && (jwt.HMACSecret != "" || jwt.JWKSFile != "") {
+		check(jwt.Issuer != "", "auth.jwt.issuer is required when JWTs are accepted")
+		check(jwt.TenantClaim != "", "auth.jwt.tenantClaim is required when JWTs are accepted")
+		check(jwt.RolesClaim != "", "auth.jwt.rolesClaim is required when JWTs are accepted")
+		check(jwt.HMACSecret == "" || len(jwt.HMACSecret) >= 32, "auth.jwt.hmacSecret must be at least 32 characters")
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		check(!c.CORS.AllowCredentials || origin != "*", "cors.allowedOrigins must list the origins when cors.allowCredentials is set, not *")
+		if origin != "*" {
+			u, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+			check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.Path == "",
+				"cors.allowedOrigins entry %q must be * or a scheme and host such as https://app.example.com", origin)
+		}
+	}
+	for _, method := range c.CORS.AllowedMethods {
+		check(corsMethodPattern.MatchString(method), "cors.allowedMethods entry %q must be an upper case HTTP method", method)
+	}
+	check(c.CORS.MaxAge >= 0, "cors.maxAge must not be negative")
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// ConnectionString is the lib/pq connection string of the database.
+func (c DatabaseConfig) ConnectionString() string {
+	if c.DSN != "" {
+		return c.DSN
+	}
+	var params []string
+	add := func(key, value string) {
+		if value != "" {
+			params = append(params, key+"="+quoteDSNValue(value))
+		}
+	}
+	add("host", c.Host)
+	if c.Port != 0 {
+		add("port", strconv.Itoa(c.Port))
+	}
+	add("user", c.User)
+	add("password", c.Password)
+	add("dbname", c.Name)
+	add("sslmode", c.SSLMode)
+	return strings.Join(params, " ")
+}
+
+func quoteDSNValue(value string) string {
+	if !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+const redacted = "[redacted]"
+
+var dsnPasswordPattern = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// redactDSN hides the password of a key=value or URL connection string.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "redacted")
+		}
+		if query := u.Query(); query.Has("password") {
+			query.Set("password", "redacted")
+			u.RawQuery = query.Encode()
+		}
+		return u.String()
+	}
+	return dsnPasswordPattern.ReplaceAllString(dsn, "${1}"+redacted)
+}
+
+// Redacted returns a copy of c with its secrets hidden.
+func (c Config) Redacted() Config {
+	for _, field := range c.fields() {
+		value := field.value.String()
+		switch {
+		case value == "":
+		case field.secret == "dsn":
+			field.value.SetString(redactDSN(value))
+		case field.secret != "":
+			field.value.SetString(redacted)
+		}
+	}
+	return c
+}
+
+// runConfigCommand implements "config print", which writes the effective
+// configuration with its secrets redacted.
+func runConfigCommand(w io.Writer, config Config, args []string) error {
+	if len(args) != 1 || args[0] != "print" {
+		return fmt.Errorf("usage: config print")
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(config.Redacted()); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return config.Validate()
+}
+
+// configField is a setting of Config that can be overridden.
+type configField struct {
+	// name is the YAML path of the field, which is also its flag name.
+	name   string
+	env    string
+	secret string
+	value  reflect.Value
+}
+
+func (c *Config) fields() []configField {
+	return structFields(reflect.ValueOf(c).Elem(), "")
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	// stringsType fields are given as comma separated values in the
+	// environment and flags.
+	stringsType = reflect.TypeOf([]string(nil))
+)
+
+func structFields(v reflect.Value, prefix string) []configField {
+	var fields []configField
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		name := strings.Split(structField.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if structField.Type.Kind() == reflect.Struct {
+			fields = append(fields, structFields(v.Field(i), name)...)
+			continue
+		}
+		fields = append(fields, configField{
+			name:   name,
+			env:    structField.Tag.Get("env"),
+			secret: structField.Tag.Get("secret"),
+			value:  v.Field(i),
+		})
+	}
+	return fields
+}
+
+func configFieldNamed(fields []configField, name string) (configField, bool) {
+	for _, field := range fields {
+		if field.name == name {
+			return field, true
+		}
+	}
+	return configField{}, false
+}
+
+func (f configField) set(value string) error {
+	switch {
+	case f.value.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s or 5m", value)
+		}
+		f.value.SetInt(int64(d))
+	case f.value.Kind() == reflect.String:
+		f.value.SetString(value)
+	case f.value.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		f.value.SetInt(int64(n))
+	case f.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		f.value.SetBool(b)
+	case f.value.Type() == stringsType:
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		f.value.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.value.Type())
+	}
+	return nil
+}
+
+// rawFlag keeps a flag's value as given so that it can be applied after the
+// config file and the environment.
+type rawFlag struct {
+	value string
+	bool  bool
+}
+
+func (f *rawFlag) String() string     { return f.value }
+func (f *rawFlag) Set(v string) error { f.value = v; return nil }
+func (f *rawFlag) IsBoolFlag() bool   { return f.bool }
@@ -0,0 +1,149 @@
+// cors.go
+
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig controls which browser origins may call the API. An origin of
+// "*" allows any origin and "https://*.example.com" any subdomain; a header
+// of "*" allows any request header.
+type CORSConfig struct {
+	AllowedOrigins   []string      `yaml:"allowedOrigins" env:"APP_CORS_ALLOWED_ORIGINS"`
+	AllowedMethods   []string      `yaml:"allowedMethods" env:"APP_CORS_ALLOWED_METHODS"`
+	AllowedHeaders   []string      `yaml:"allowedHeaders" env:"APP_CORS_ALLOWED_HEADERS"`
+	ExposedHeaders   []string      `yaml:"exposedHeaders" env:"APP_CORS_EXPOSED_HEADERS"`
+	AllowCredentials bool          `yaml:"allowCredentials" env:"APP_CORS_ALLOW_CREDENTIALS"`
+	MaxAge           time.Duration `yaml:"maxAge" env:"APP_CORS_MAX_AGE"`
+}
+
+var corsMethodPattern = regexp.MustCompile(`^[A-Z]+$`)
+
+func defaultCORSConfig() CORSConfig {
+	return CORSConfig{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+		AllowedHeaders: []string{"Authorization", "Content-Type", "X-API-Key", requestIDHeader},
+		ExposedHeaders: []string{requestIDHeader, "X-Total-Count", "X-Next-Cursor", "Link"},
+		MaxAge:         10 * time.Minute,
+	}
+}
+
+func (c CORSConfig) allowsOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if scheme, domain, ok := strings.Cut(allowed, "://*."); ok &&
+			strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(strings.ToLower(origin), "."+strings.ToLower(domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c CORSConfig) allowsMethod(method string) bool {
+	for _, allowed := range c.AllowedMethods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHeaders reports whether every header of an
+// Access-Control-Request-Headers value is allowed.
+func (c CORSConfig) allowsHeaders(requested string) bool {
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		allowed := false
+		for _, h := range c.AllowedHeaders {
+			if h == "*" || strings.EqualFold(h, header) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// corsMiddleware adds the CORS headers to responses for allowed origins and
+// answers preflight requests itself. Preflights reach it through the OPTIONS
+// route that initializeRoutes adds for every path.
+func (a *App) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		preflight := r.Method == http.MethodOptions && requestedMethod != ""
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+		}
+		if !a.CORS.allowsOrigin(origin) {
+			if preflight {
+				respondWithError(w, http.StatusForbidden, "Origin "+origin+" is not allowed")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if a.CORS.AllowCredentials || !a.CORS.allowsOrigin("*") {
+			header.Set("Access-Control-Allow-Origin", origin)
+		} else {
+			header.Set("Access-Control-Allow-Origin", "*")
+		}
+		if a.CORS.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight {
+			if len(a.CORS.ExposedHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(a.CORS.ExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		requestedHeaders := r.Header.Get("Access-Control-Request-Headers")
+		if !a.CORS.allowsMethod(requestedMethod) {
+			respondWithError(w, http.StatusForbidden, "Method "+requestedMethod+" is not allowed")
+			return
+		}
+		if !a.CORS.allowsHeaders(requestedHeaders) {
+			respondWithError(w, http.StatusForbidden, "Headers "+requestedHeaders+" are not allowed")
+			return
+		}
+		header.Set("Access-Control-Allow-Methods", strings.Join(a.CORS.AllowedMethods, ", "))
+		if requestedHeaders != "" {
+			header.Set("Access-Control-Allow-Headers", requestedHeaders)
+		}
+		if a.CORS.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(a.CORS.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// preflight answers OPTIONS requests that are not CORS preflights, which
+// corsMiddleware has not answered already.
+func (a *App) preflight(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", strings.Join(append([]string{http.MethodOptions}, a.CORS.AllowedMethods...), ", "))
+	w.WriteHeader(http.StatusNoContent)
+}
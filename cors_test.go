@@ -0,0 +1,165 @@
+// cors_test.go
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSConfigAllowsOrigin(t *testing.T) {
+	config := CORSConfig{AllowedOrigins: []string{"https://app.example.com", "https://*.xplat.example.com"}}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"https://ui.xplat.example.com", true},
+		{"https://a.b.xplat.example.com", true},
+		{"https://xplat.example.com", false},
+		{"http://ui.xplat.example.com", false},
+		{"https://evilxplat.example.com", false},
+		{"https://ui.xplat.example.com.evil.com", false},
+		{"https://other.example.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := config.allowsOrigin(tt.origin); got != tt.want {
+			t.Errorf("allowsOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+	if !(CORSConfig{AllowedOrigins: []string{"*"}}).allowsOrigin("https://anything.test") {
+		t.Errorf("allowsOrigin with * = false, want true")
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	wildcard := defaultCORSConfig()
+	credentials := defaultCORSConfig()
+	credentials.AllowedOrigins = []string{"https://*.example.com"}
+	credentials.AllowCredentials = true
+
+	tests := []struct {
+		name    string
+		config  CORSConfig
+		method  string
+		headers map[string]string
+
+		wantStatus      int
+		wantNext        bool
+		wantAllowOrigin string
+		wantHeaders     map[string]string
+	}{
+		{
+			name:        "no origin",
+			config:      wildcard,
+			method:      http.MethodGet,
+			wantStatus:  http.StatusOK,
+			wantNext:    true,
+			wantHeaders: map[string]string{"Vary": ""},
+		},
+		{
+			name:            "simple request",
+			config:          wildcard,
+			method:          http.MethodGet,
+			headers:         map[string]string{"Origin": "https://ui.example.com"},
+			wantStatus:      http.StatusOK,
+			wantNext:        true,
+			wantAllowOrigin: "*",
+			wantHeaders:     map[string]string{"Access-Control-Expose-Headers": "X-Request-ID, X-Total-Count, X-Next-Cursor, Link", "Vary": "Origin"},
+		},
+		{
+			name:   "PATCH preflight",
+			config: wildcard,
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://ui.example.com",
+				"Access-Control-Request-Method":  "PATCH",
+				"Access-Control-Request-Headers": "authorization, content-type",
+			},
+			wantStatus:      http.StatusNoContent,
+			wantAllowOrigin: "*",
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Methods": "GET, POST, PUT, PATCH, DELETE",
+				"Access-Control-Allow-Headers": "authorization, content-type",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name:            "preflight of a method that is not allowed",
+			config:          wildcard,
+			method:          http.MethodOptions,
+			headers:         map[string]string{"Origin": "https://ui.example.com", "Access-Control-Request-Method": "TRACE"},
+			wantStatus:      http.StatusForbidden,
+			wantAllowOrigin: "*",
+		},
+		{
+			name:   "preflight of a header that is not allowed",
+			config: wildcard,
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://ui.example.com",
+				"Access-Control-Request-Method":  "GET",
+				"Access-Control-Request-Headers": "X-Evil",
+			},
+			wantStatus:      http.StatusForbidden,
+			wantAllowOrigin: "*",
+		},
+		{
+			name:            "credentials echo the origin",
+			config:          credentials,
+			method:          http.MethodOptions,
+			headers:         map[string]string{"Origin": "https://ui.example.com", "Access-Control-Request-Method": "DELETE"},
+			wantStatus:      http.StatusNoContent,
+			wantAllowOrigin: "https://ui.example.com",
+			wantHeaders:     map[string]string{"Access-Control-Allow-Credentials": "true"},
+		},
+		{
+			name:       "preflight from an origin that is not allowed",
+			config:     credentials,
+			method:     http.MethodOptions,
+			headers:    map[string]string{"Origin": "https://evil.com", "Access-Control-Request-Method": "GET"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "request from an origin that is not allowed",
+			config:     credentials,
+			method:     http.MethodGet,
+			headers:    map[string]string{"Origin": "https://evil.com"},
+			wantStatus: http.StatusOK,
+			wantNext:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &App{CORS: tt.config}
+			called := false
+			handler := a.corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}))
+			r := httptest.NewRequest(tt.method, "/issue", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if called != tt.wantNext {
+				t.Errorf("next handler called = %v, want %v", called, tt.wantNext)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantAllowOrigin)
+			}
+			for k, want := range tt.wantHeaders {
+				if got := w.Header().Get(k); got != want {
+					t.Errorf("%s = %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+// dedup.go
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultDedupWindow is how long after an issue was last reported that a
+// report with the same fingerprint is counted against it instead of opening
+// a new ticket.
+const defaultDedupWindow = time.Hour
+
+var (
+	uuidPattern   = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+	hexPattern    = regexp.MustCompile(`\b(0x)?[0-9a-f]{8,}\b`)
+	numberPattern = regexp.MustCompile(`\d+`)
+	spacePattern  = regexp.MustCompile(`\s+`)
+)
+
+// normalizeContent strips what differs between two reports of the same
+// problem: case, whitespace, ids and numbers such as timestamps, addresses
+// and counters.
+func normalizeContent(content string) string {
+	content = strings.ToLower(content)
+	content = uuidPattern.ReplaceAllString(content, "<uuid>")
+	content = hexPattern.ReplaceAllString(content, "<hex>")
+	content = numberPattern.ReplaceAllString(content, "<n>")
+	return strings.TrimSpace(spacePattern.ReplaceAllString(content, " "))
+}
+
+// issueFingerprint identifies reports of the same problem from the same
+// place.
+func issueFingerprint(i IssueRequest) string {
+	h := sha256.New()
+	for _, part := range []string{i.ErrorCode, i.TenantID, i.VpcID, i.Service, normalizeContent(i.Content)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
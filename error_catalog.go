@@ -0,0 +1,171 @@
+// error_catalog.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	catalogFormatYAML = "yaml"
+	catalogFormatCSV  = "csv"
+
+	maxCatalogImportSize = 10 << 20
+)
+
+// catalogColumns is the CSV header of an exported catalog. Imported CSV files
+// must have an errorCode column; the other columns are optional and may be in
+// any order.
+var catalogColumns = []string{"errorCode", "name", "description", "service"}
+
+// catalogEntry is one error definition in an imported or exported catalog
+// file.
+type catalogEntry struct {
+	ErrorCode   string `yaml:"errorCode"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Service     string `yaml:"service"`
+}
+
+// CatalogImportReport lists the error codes an import created, updated or
+// left alone. With DryRun nothing was written.
+type CatalogImportReport struct {
+	DryRun    bool     `json:"dryRun"`
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Unchanged []string `json:"unchanged"`
+}
+
+// catalogFormat picks the file format from an explicit format parameter, or
+// else from a Content-Type or Accept header. YAML is the default.
+func catalogFormat(format, mediaType string) (string, error) {
+	switch strings.ToLower(format) {
+	case catalogFormatYAML, "yml":
+		return catalogFormatYAML, nil
+	case catalogFormatCSV:
+		return catalogFormatCSV, nil
+	case "":
+	default:
+		return "", fmt.Errorf("format must be yaml or csv")
+	}
+	if strings.Contains(strings.ToLower(mediaType), "csv") {
+		return catalogFormatCSV, nil
+	}
+	return catalogFormatYAML, nil
+}
+
+// parseCatalog reads an error catalog and validates every entry. Problems are
+// reported together with the entry they were found in.
+func parseCatalog(format string, data []byte) ([]ErrorStore, error) {
+	var entries []catalogEntry
+	var err error
+	if format == catalogFormatCSV {
+		entries, err = parseCatalogCSV(data)
+	} else {
+		err = yaml.Unmarshal(data, &entries)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse error catalog: [%s]", err.Error())
+	}
+
+	var problems []string
+	seen := make(map[string]int, len(entries))
+	catalog := make([]ErrorStore, 0, len(entries))
+	for n, entry := range entries {
+		e := ErrorStore{
+			ErrorCode:   strings.TrimSpace(entry.ErrorCode),
+			Name:        strings.TrimSpace(entry.Name),
+			Description: entry.Description,
+			Service:     strings.TrimSpace(entry.Service),
+		}
+		if err := e.Validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("entry %d: %s", n+1, err.Error()))
+			continue
+		}
+		if first, ok := seen[e.ErrorCode]; ok {
+			problems = append(problems, fmt.Sprintf("entry %d: error code %s is already defined by entry %d", n+1, e.ErrorCode, first))
+			continue
+		}
+		seen[e.ErrorCode] = n + 1
+		catalog = append(catalog, e)
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("Invalid error catalog: %s", strings.Join(problems, "; "))
+	}
+	return catalog, nil
+}
+
+func parseCatalogCSV(data []byte) ([]catalogEntry, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, column := range header {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	if _, ok := columns["errorcode"]; !ok {
+		return nil, fmt.Errorf("the header has no errorCode column")
+	}
+	field := func(record []string, column string) string {
+		if i, ok := columns[column]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	var entries []catalogEntry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, catalogEntry{
+			ErrorCode:   field(record, "errorcode"),
+			Name:        field(record, "name"),
+			Description: field(record, "description"),
+			Service:     field(record, "service"),
+		})
+	}
+}
+
+// writeCatalog encodes the catalog in the given format.
+func writeCatalog(w io.Writer, format string, catalog []ErrorStore) error {
+	if format == catalogFormatCSV {
+		writer := csv.NewWriter(w)
+		if err := writer.Write(catalogColumns); err != nil {
+			return err
+		}
+		for _, e := range catalog {
+			if err := writer.Write([]string{e.ErrorCode, e.Name, e.Description, e.Service}); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+
+	entries := make([]catalogEntry, 0, len(catalog))
+	for _, e := range catalog {
+		entries = append(entries, catalogEntry{ErrorCode: e.ErrorCode, Name: e.Name, Description: e.Description, Service: e.Service})
+	}
+	encoder := yaml.NewEncoder(w)
+	if err := encoder.Encode(entries); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
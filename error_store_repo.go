@@ -0,0 +1,150 @@
+// error_store_repo.go
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrErrorCodeNotFound is returned when no catalog entry has the error code.
+	ErrErrorCodeNotFound = errors.New("error code not found")
+	// ErrErrorCodeExists is returned when creating an error code that is
+	// already in the catalog.
+	ErrErrorCodeExists = errors.New("error code already exists")
+)
+
+const errorStoreColumns = "id, error_code, name, description, service, created_at, updated_at"
+
+func scanErrorStore(row rowScanner, e *ErrorStore) error {
+	err := row.Scan(&e.ID, &e.ErrorCode, &e.Name, &e.Description, &e.Service, &e.CreatedAt, &e.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrErrorCodeNotFound
+	}
+	return err
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+type ErrorStoreRepo struct {
+	repo
+}
+
+func NewErrorStoreRepo(db *sql.DB) *ErrorStoreRepo {
+	return &ErrorStoreRepo{repo{db: db}}
+}
+
+func (r *ErrorStoreRepo) WithTx(tx *sql.Tx) *ErrorStoreRepo {
+	return &ErrorStoreRepo{repo{db: r.db, tx: tx}}
+}
+
+// Create inserts errorStore and returns ErrErrorCodeExists when its error
+// code is already in the catalog.
+func (r *ErrorStoreRepo) Create(ctx context.Context, errorStore *ErrorStore) error {
+	err := r.q().QueryRowContext(ctx, "INSERT INTO error_store(error_code, name, description, service, created_at, updated_at) VALUES($1, $2, $3, $4, $5, $6) RETURNING id",
+		errorStore.ErrorCode, errorStore.Name, errorStore.Description, errorStore.Service, errorStore.CreatedAt, errorStore.UpdatedAt).Scan(&errorStore.ID)
+	if isUniqueViolation(err) {
+		return ErrErrorCodeExists
+	}
+	return err
+}
+
+func (r *ErrorStoreRepo) Get(ctx context.Context, errorCode string) (ErrorStore, error) {
+	var e ErrorStore
+	err := scanErrorStore(r.q().QueryRowContext(ctx, "SELECT "+errorStoreColumns+" FROM error_store WHERE error_code=$1", errorCode), &e)
+	return e, err
+}
+
+func (r *ErrorStoreRepo) List(ctx context.Context) ([]ErrorStore, error) {
+	rows, err := r.q().QueryContext(ctx, "SELECT "+errorStoreColumns+" FROM error_store ORDER BY error_code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []ErrorStore{}
+	for rows.Next() {
+		var e ErrorStore
+		if err := scanErrorStore(rows, &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Update replaces the name, description and service of the entry with the
+// error code of errorStore, and returns ErrErrorCodeNotFound when there is
+// none.
+func (r *ErrorStoreRepo) Update(ctx context.Context, errorStore *ErrorStore) error {
+	errorStore.UpdatedAt = time.Now()
+	return scanErrorStore(r.q().QueryRowContext(ctx, "UPDATE error_store SET name=$1, description=$2, service=$3, updated_at=$4 WHERE error_code=$5 RETURNING "+errorStoreColumns,
+		errorStore.Name, errorStore.Description, errorStore.Service, errorStore.UpdatedAt, errorStore.ErrorCode), errorStore)
+}
+
+func (r *ErrorStoreRepo) Delete(ctx context.Context, errorCode string) error {
+	res, err := r.q().ExecContext(ctx, "DELETE FROM error_store WHERE error_code=$1", errorCode)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrErrorCodeNotFound
+	}
+	return nil
+}
+
+// Import creates or updates every entry of catalog in a single transaction
+// and reports what changed. With dryRun the report is computed the same way
+// but nothing is written. Error codes that are not in catalog are kept.
+func (r *ErrorStoreRepo) Import(ctx context.Context, catalog []ErrorStore, dryRun bool) (CatalogImportReport, error) {
+	report := CatalogImportReport{DryRun: dryRun, Created: []string{}, Updated: []string{}, Unchanged: []string{}}
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		store := r.WithTx(tx)
+		if !dryRun {
+			// Keep concurrent imports and POST /error from racing on new codes.
+			if _, err := traced(tx).ExecContext(ctx, "LOCK TABLE error_store IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		for _, e := range catalog {
+			current, err := store.Get(ctx, e.ErrorCode)
+			switch {
+			case errors.Is(err, ErrErrorCodeNotFound):
+				report.Created = append(report.Created, e.ErrorCode)
+				if dryRun {
+					continue
+				}
+				e.CreatedAt, e.UpdatedAt = now, now
+				if err := store.Create(ctx, &e); err != nil {
+					return err
+				}
+			case err != nil:
+				return err
+			case current.Name == e.Name && current.Description == e.Description && current.Service == e.Service:
+				report.Unchanged = append(report.Unchanged, e.ErrorCode)
+			default:
+				report.Updated = append(report.Updated, e.ErrorCode)
+				if dryRun {
+					continue
+				}
+				if err := store.Update(ctx, &e); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return report, err
+}
@@ -1,8 +1,9 @@
 module github.com/xplat/hickathon
 
-go 1.18
+go 1.21
 
 require (
 	github.com/gorilla/mux v1.8.0
 	github.com/lib/pq v1.10.6
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,95 @@
+// health.go
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const defaultReadyTimeout = 2 * time.Second
+
+// CheckResult is the outcome of one readiness check.
+type CheckResult struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ReadyReport is the body of GET /readyz.
+type ReadyReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+func runCheck(ctx context.Context, timeout time.Duration, check func(ctx context.Context) error) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	err := check(ctx)
+	result := CheckResult{Status: "ok", LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// checkMigrations fails when a migration is pending or was modified after it
+// was applied.
+func (a *App) checkMigrations(ctx context.Context) error {
+	migrator, err := NewMigrator(a.DB)
+	if err != nil {
+		return err
+	}
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		switch {
+		case !s.Applied:
+			return fmt.Errorf("Migration %04d_%s is pending", s.Version, s.Name)
+		case s.Modified:
+			return fmt.Errorf("Migration %04d_%s was modified after it was applied", s.Version, s.Name)
+		}
+	}
+	return nil
+}
+
+// healthz reports that the process is up; it checks no dependencies.
+func (a *App) healthz(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz checks the database and the migrations and, when ReadyCheckTracker
+// is set, the tracker. Every check gets ReadyTimeout. It answers 503 when any
+// check fails. The route is public, so callers cannot turn on checks that
+// reach Jira.
+func (a *App) readyz(w http.ResponseWriter, r *http.Request) {
+	timeout := a.ReadyTimeout
+	if timeout <= 0 {
+		timeout = defaultReadyTimeout
+	}
+	report := ReadyReport{Status: "ready", Checks: map[string]CheckResult{}}
+	report.Checks["database"] = runCheck(r.Context(), timeout, a.DB.PingContext)
+	if report.Checks["database"].Status == "ok" {
+		report.Checks["migrations"] = runCheck(r.Context(), timeout, a.checkMigrations)
+	} else {
+		report.Checks["migrations"] = CheckResult{Status: "skipped", Error: "database is unreachable"}
+	}
+	if a.ReadyCheckTracker {
+		report.Checks["tracker"] = runCheck(r.Context(), timeout, a.Tracker.Ping)
+	}
+
+	status := http.StatusOK
+	for _, check := range report.Checks {
+		if check.Status != "ok" {
+			report.Status = "not_ready"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	respondWithJSON(w, status, report)
+}
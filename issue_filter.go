@@ -0,0 +1,210 @@
+// issue_filter.go
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultIssuePageSize = 50
+	maxIssuePageSize     = 500
+)
+
+// issueSortColumns are the columns GET /issue can be sorted by.
+var issueSortColumns = map[string]bool{
+	"id":         true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+// IssueFilter selects one page of issues for GET /issue.
+type IssueFilter struct {
+	TenantID    string
+	RegionID    string
+	Service     string
+	Statuses    []string
+	ErrorCode   string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	Sort        string
+	Desc        bool
+	Limit       int
+	After       *issueCursor
+}
+
+// issueCursor marks the last issue of a page: its value in the sort column
+// and its id, which breaks ties.
+type issueCursor struct {
+	Sort string    `json:"s"`
+	Desc bool      `json:"d,omitempty"`
+	Time time.Time `json:"t,omitempty"`
+	ID   int       `json:"id"`
+}
+
+func (c issueCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeIssueCursor(s string) (*issueCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cursor")
+	}
+	var c issueCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("Invalid cursor")
+	}
+	return &c, nil
+}
+
+func cursorAfter(issue Issues, filter IssueFilter) issueCursor {
+	c := issueCursor{Sort: filter.Sort, Desc: filter.Desc, ID: issue.ID}
+	switch filter.Sort {
+	case "created_at":
+		c.Time = issue.CreatedAt
+	case "updated_at":
+		c.Time = issue.UpdatedAt
+	}
+	return c
+}
+
+// parseTimeParam accepts RFC 3339 timestamps or plain dates.
+func parseTimeParam(name, value string) (*time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return &t, nil
+	}
+	return nil, fmt.Errorf("%s must be an RFC 3339 timestamp or a YYYY-MM-DD date", name)
+}
+
+// parseIssueFilter reads the filter, sort and pagination query parameters of
+// GET /issue.
+func parseIssueFilter(query url.Values) (IssueFilter, error) {
+	filter := IssueFilter{
+		TenantID:  query.Get("tenant_id"),
+		RegionID:  query.Get("region_id"),
+		Service:   query.Get("service"),
+		ErrorCode: query.Get("error_code"),
+		Sort:      "id",
+		Limit:     defaultIssuePageSize,
+	}
+	if status := query.Get("status"); status != "" {
+		for _, s := range strings.Split(status, ",") {
+			if s = normalizeStatus(s); s != "" {
+				filter.Statuses = append(filter.Statuses, s)
+			}
+		}
+	}
+
+	var err error
+	if value := query.Get("created_from"); value != "" {
+		if filter.CreatedFrom, err = parseTimeParam("created_from", value); err != nil {
+			return filter, err
+		}
+	}
+	if value := query.Get("created_to"); value != "" {
+		if filter.CreatedTo, err = parseTimeParam("created_to", value); err != nil {
+			return filter, err
+		}
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		filter.Desc = strings.HasPrefix(sort, "-")
+		filter.Sort = strings.TrimPrefix(sort, "-")
+		if !issueSortColumns[filter.Sort] {
+			return filter, fmt.Errorf("sort must be one of id, created_at or updated_at, optionally prefixed with -")
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxIssuePageSize {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxIssuePageSize)
+		}
+		filter.Limit = limit
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := decodeIssueCursor(value)
+		if err != nil {
+			return filter, err
+		}
+		if cursor.Sort != filter.Sort || cursor.Desc != filter.Desc {
+			return filter, fmt.Errorf("cursor was issued for a different sort order")
+		}
+		filter.After = cursor
+	}
+	return filter, nil
+}
+
+// where builds the WHERE clause and arguments for filter. The cursor is only
+// included when withCursor is set, so the same conditions can be used to
+// count every matching issue.
+func (filter IssueFilter) where(withCursor bool) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.Replace(condition, "?", "$"+strconv.Itoa(len(args)), -1))
+	}
+	if filter.TenantID != "" {
+		add("tenant_id = ?", filter.TenantID)
+	}
+	if filter.RegionID != "" {
+		add("region_id = ?", filter.RegionID)
+	}
+	if filter.Service != "" {
+		add("service = ?", filter.Service)
+	}
+	if len(filter.Statuses) > 0 {
+		add("upper(status) = ANY(?)", pq.Array(filter.Statuses))
+	}
+	if filter.ErrorCode != "" {
+		add("error_code = ?", filter.ErrorCode)
+	}
+	if filter.CreatedFrom != nil {
+		add("created_at >= ?", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		add("created_at < ?", *filter.CreatedTo)
+	}
+	if withCursor && filter.After != nil {
+		op := ">"
+		if filter.Desc {
+			op = "<"
+		}
+		if filter.Sort == "id" {
+			add("id "+op+" ?", filter.After.ID)
+		} else {
+			args = append(args, filter.After.Time, filter.After.ID)
+			conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d, $%d)", filter.Sort, op, len(args)-1, len(args)))
+		}
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func (filter IssueFilter) orderBy() string {
+	direction := "ASC"
+	if filter.Desc {
+		direction = "DESC"
+	}
+	if filter.Sort == "id" {
+		return " ORDER BY id " + direction
+	}
+	return fmt.Sprintf(" ORDER BY %s %s, id %s", filter.Sort, direction, direction)
+}
@@ -0,0 +1,133 @@
+// issue_filter_test.go
+
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseIssueFilter(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 2, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		query   string
+		want    IssueFilter
+		wantErr string
+	}{
+		{
+			name:  "defaults",
+			query: "",
+			want:  IssueFilter{Sort: "id", Limit: defaultIssuePageSize},
+		},
+		{
+			name:  "filters",
+			query: "tenant_id=t1&region_id=r1&service=vm&error_code=vm_001&status=to%20do,%20Done,&created_from=2024-03-01&created_to=2024-03-02T12:30:00Z",
+			want: IssueFilter{
+				TenantID:    "t1",
+				RegionID:    "r1",
+				Service:     "vm",
+				ErrorCode:   "vm_001",
+				Statuses:    []string{"TO DO", "DONE"},
+				CreatedFrom: &from,
+				CreatedTo:   &to,
+				Sort:        "id",
+				Limit:       defaultIssuePageSize,
+			},
+		},
+		{
+			name:  "descending sort and limit",
+			query: "sort=-created_at&limit=10",
+			want:  IssueFilter{Sort: "created_at", Desc: true, Limit: 10},
+		},
+		{name: "unknown sort column", query: "sort=name", wantErr: "sort must be one of"},
+		{name: "zero limit", query: "limit=0", wantErr: "limit must be between"},
+		{name: "limit too large", query: "limit=501", wantErr: "limit must be between"},
+		{name: "limit not a number", query: "limit=ten", wantErr: "limit must be between"},
+		{name: "bad date", query: "created_from=yesterday", wantErr: "created_from must be"},
+		{name: "bad cursor", query: "cursor=!!!", wantErr: "Invalid cursor"},
+		{
+			name:    "cursor of another sort order",
+			query:   "sort=created_at&cursor=" + issueCursor{Sort: "id", ID: 3}.encode(),
+			wantErr: "different sort order",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseIssueFilter(query)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseIssueFilter() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseIssueFilter() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseIssueFilter() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIssueCursorRoundTrip(t *testing.T) {
+	issue := Issues{}
+	issue.ID = 42
+	issue.CreatedAt = time.Date(2024, 3, 1, 10, 0, 0, 123456000, time.UTC)
+	issue.UpdatedAt = time.Date(2024, 3, 5, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		sort      string
+		wantWhere string
+		wantArgs  []interface{}
+	}{
+		{"id", " WHERE id > $1", []interface{}{42}},
+		{"-id", " WHERE id < $1", []interface{}{42}},
+		{"created_at", " WHERE (created_at, id) > ($1, $2)", []interface{}{issue.CreatedAt, 42}},
+		{"-updated_at", " WHERE (updated_at, id) < ($1, $2)", []interface{}{issue.UpdatedAt, 42}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			first, err := parseIssueFilter(url.Values{"sort": {tt.sort}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cursor := cursorAfter(issue, first).encode()
+			next, err := parseIssueFilter(url.Values{"sort": {tt.sort}, "cursor": {cursor}})
+			if err != nil {
+				t.Fatalf("parseIssueFilter() with cursor error = %v", err)
+			}
+			if next.After == nil || next.After.ID != issue.ID {
+				t.Fatalf("cursor = %+v, want id %d", next.After, issue.ID)
+			}
+			where, args := next.where(true)
+			if where != tt.wantWhere {
+				t.Errorf("where = %q, want %q", where, tt.wantWhere)
+			}
+			for i := range args {
+				if want, ok := tt.wantArgs[i].(time.Time); ok {
+					if got, _ := args[i].(time.Time); !got.Equal(want) {
+						t.Errorf("arg %d = %v, want %v", i, args[i], want)
+					}
+				} else if args[i] != tt.wantArgs[i] {
+					t.Errorf("arg %d = %v, want %v", i, args[i], tt.wantArgs[i])
+				}
+			}
+			if len(args) != len(tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+			if where, _ := next.where(false); where != "" {
+				t.Errorf("where without cursor = %q, want none", where)
+			}
+		})
+	}
+}
@@ -0,0 +1,470 @@
+// issue_repo.go
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrIssueNotFound is returned when a lookup or update targets an issue that
+// does not exist.
+var ErrIssueNotFound = errors.New("issue not found")
+
+// ErrIssueInJira and ErrIssueQueued are returned by MarkPending for issues
+// that need not be filed in Jira again.
+var (
+	ErrIssueInJira = errors.New("issue is already in Jira")
+	ErrIssueQueued = errors.New("issue is already queued for Jira")
+)
+
+// issueColumns is the column list scanIssue expects.
+const issueColumns = "id, tenant_id, vpc_id, region_id, issue_jira_id, issue_jira_key, name, data_log, error_code, status, service, assignee, priority, fingerprint, occurrence_count, last_seen_at, sync_state, created_at, updated_at"
+
+func scanIssue(row rowScanner, issue *Issues) error {
+	err := row.Scan(&issue.ID, &issue.TenantID, &issue.VpcID, &issue.RegionID, &issue.IssueJiraID, &issue.IssueJiraKey, &issue.Name,
+		&issue.DataLog, &issue.ErrorCode, &issue.Status, &issue.Service, &issue.Assignee, &issue.Priority,
+		&issue.Fingerprint, &issue.OccurrenceCount, &issue.LastSeenAt, &issue.SyncState, &issue.CreatedAt, &issue.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrIssueNotFound
+	}
+	return err
+}
+
+// issueRefCondition matches the issue whose Jira id or key is $1. Queries
+// using it order by issue_jira_id=$1 DESC so that an id wins over a key.
+// Pending issues have neither, so callers must not look up an empty ref.
+const issueRefCondition = "(issue_jira_id=$1 OR (issue_jira_key <> '' AND issue_jira_key=$1))"
+
+// closedStatuses are the statuses the sync worker no longer polls for.
+var closedStatuses = []string{"DONE", "CLOSED", "RESOLVED", "DELETED"}
+
+// IssueRepo reads and writes issues within the tenant scope of the context:
+// the principal's tenant, or every tenant for operators and for contexts made
+// with withSystemScope. Issues outside the scope are not found.
+type IssueRepo struct {
+	repo
+}
+
+// IssueStore is the part of IssueRepo the outbox dispatcher and the sync
+// worker use, so that they can be tested without Postgres.
+type IssueStore interface {
+	GetByID(ctx context.Context, id int) (Issues, error)
+	ListOpen(ctx context.Context, afterID, limit int) ([]Issues, error)
+	UpdateStatus(ctx context.Context, issue *Issues, change StatusChange) error
+}
+
+func NewIssueRepo(db *sql.DB) *IssueRepo {
+	return &IssueRepo{repo{db: db}}
+}
+
+func (r *IssueRepo) WithTx(tx *sql.Tx) *IssueRepo {
+	return &IssueRepo{repo{db: r.db, tx: tx}}
+}
+
+// Create inserts issue and sets its ID. OccurrenceCount defaults to 1,
+// LastSeenAt to CreatedAt and SyncState to synced.
+func (r *IssueRepo) Create(ctx context.Context, issue *Issues) error {
+	scope, err := tenantScopeFrom(ctx)
+	if err != nil {
+		return err
+	}
+	if err := scope.check(issue.TenantID); err != nil {
+		return err
+	}
+	if issue.OccurrenceCount == 0 {
+		issue.OccurrenceCount = 1
+	}
+	if issue.LastSeenAt.IsZero() {
+		issue.LastSeenAt = issue.CreatedAt
+	}
+	if issue.SyncState == "" {
+		issue.SyncState = syncStateSynced
+	}
+	if err := r.q().QueryRowContext(ctx, "INSERT INTO issues(tenant_id, vpc_id, region_id, issue_jira_id, issue_jira_key, name, data_log, error_code, status, service, assignee, priority, fingerprint, occurrence_count, last_seen_at, sync_state, created_at, updated_at) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18) RETURNING id",
+		issue.TenantID, issue.VpcID, issue.RegionID, issue.IssueJiraID, issue.IssueJiraKey, issue.Name, issue.DataLog, issue.ErrorCode, issue.Status, issue.Service,
+		issue.Assignee, issue.Priority, issue.Fingerprint, issue.OccurrenceCount, issue.LastSeenAt, issue.SyncState, issue.CreatedAt, issue.UpdatedAt).Scan(&issue.ID); err != nil {
+		return err
+	}
+	scope.audit(ctx, "issue.create", *issue)
+	return nil
+}
+
+func (r *IssueRepo) GetByID(ctx context.Context, id int) (Issues, error) {
+	var issue Issues
+	scope, err := tenantScopeFrom(ctx)
+	if err != nil {
+		return issue, err
+	}
+	condition, args := scope.and([]interface{}{id})
+	if err := scanIssue(r.q().QueryRowContext(ctx, "SELECT "+issueColumns+" FROM issues WHERE id=$1"+condition, args...), &issue); err != nil {
+		return issue, err
+	}
+	scope.audit(ctx, "issue.get", issue)
+	return issue, nil
+}
+
+// GetByJiraRef loads the issue with the given Jira id, or with the given Jira
+// key when the id does not match. Empty refs match no issue, not the pending
+// ones.
+func (r *IssueRepo) GetByJiraRef(ctx context.Context, issueJiraID, issueJiraKey string) (Issues, error) {
+	var issue Issues
+	if issueJiraID == "" && issueJiraKey == "" {
+		return issue, ErrIssueNotFound
+	}
+	scope, err := tenantScopeFrom(ctx)
+	if err != nil {
+		return issue, err
+	}
+	condition, args := scope.and([]interface{}{issueJiraID, issueJiraKey})
+	if err := scanIssue(r.q().QueryRowContext(ctx, "SELECT "+issueColumns+" FROM issues WHERE ((issue_jira_id <> '' AND issue_jira_id=$1) OR (issue_jira_key <> '' AND issue_jira_key=$2))"+condition+" ORDER BY issue_jira_id=$1 DESC LIMIT 1",
+		args...), &issue); err != nil {
+		return issue, err
+	}
+	scope.audit(ctx, "issue.get", issue)
+	return issue, nil
+}
+
+// GetStatus returns the status of the issue with the given Jira id or key.
+func (r *IssueRepo) GetStatus(ctx context.Context, issueJiraRef string) (string, error) {
+	if issueJiraRef == "" {
+		return "", ErrIssueNotFound
+	}
+	scope, err := tenantScopeFrom(ctx)
+	if err != nil {
+		return "", err
+	}
+	var issue Issues
+	condition, args := scope.and([]interface{}{issueJiraRef})
+	err = r.q().QueryRowContext(ctx, "SELECT id, tenant_id, status FROM issues WHERE "+issueRefCondition+condition+" ORDER BY issue_jira_id=$1 DESC, id LIMIT 1",
+		args...).Scan(&issue.ID, &issue.TenantID, &issue.Status)
+	if err == sql.ErrNoRows {
+		return "", ErrIssueNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	scope.audit(ctx, "issue.get_status", issue)
+	return issue.Status, nil
+}
+
+// IssuePage is one page of GET /issue. NextCursor is empty on the last page.
+type IssuePage struct {
+	Issues     []Issues
+	Total      int
+	NextCursor string
+}
+
+// ListPage returns the page of issues selected by filter together with the
+// number of issues matching the filter across all pages.
+func (r *IssueRepo) ListPage(ctx context.Context, filter IssueFilter) (IssuePage, error) {
+	page := IssuePage{Issues: []Issues{}}
+	scope, err := tenantScopeFrom(ctx)
+	if err != nil {
+		return page, err
+	}
+	where, args := scope.where(filter.where(false))
+	if err := r.q().QueryRowContext(ctx, "SELECT count(*) FROM issues"+where, args...).Scan(&page.Total); err != nil {
+		return page, err
+	}
+
+	where, args = scope.where(filter.where(true))
+	args = append(args, filter.Limit+1)
+	query := "SELECT " + issueColumns + " FROM issues" + where + filter.orderBy() + " LIMIT $" + strconv.Itoa(len(args))
+	rows, err := r.q().QueryContext(ctx, query, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i Issues
+		if err := scanIssue(rows, &i); err != nil {
+			return page, err
+		}
+		page.Issues = append(page.Issues, i)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+	if len(page.Issues) > filter.Limit {
+		page.Issues = page.Issues[:filter.Limit]
+		page.NextCursor = cursorAfter(page.Issues[filter.Limit-1], filter).encode()
+	}
+	scope.audit(ctx, "issue.list", page.Issues...)
+	return page, nil
+}
+
+// ListOpen returns up to limit open issues that have a Jira id, ordered by id
+// and starting after afterID.
+func (r *IssueRepo) ListOpen(ctx context.Context, afterID, limit int) ([]Issues, error) {
+	scope, err := tenantScopeFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	condition, args := scope.and([]interface{}{afterID, pq.Array(closedStatuses), limit})
+	rows, err := r.q().QueryContext(ctx, "SELECT "+issueColumns+" FROM issues WHERE id > $1 AND issue_jira_id <> '' AND upper(status) <> ALL($2)"+condition+" ORDER BY id LIMIT $3",
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	issues := []Issues{}
+	for rows.Next() {
+		var i Issues
+		if err := scanIssue(rows, &i); err != nil {
+			return nil, err
+		}
+		issues = append(issues, i)
+	}
+	return issues, rows.Err()
+}
+
+// UpdateStatus sets the status of issue, found by its primary key or else by
+// its Jira id or key, and appends a step_log entry in the same transaction.
+// A Jira reference matches one issue, preferring a match on the id as
+// GetByJiraRef does. It returns ErrIssueNotFound when there is none.
+func (r *IssueRepo) UpdateStatus(ctx context.Context, issue *Issues, change StatusChange) error {
+	if issue.ID == 0 && issue.IssueJiraID == "" {
+		return ErrIssueNotFound
+	}
+	var current Issues
+	scope, err := tenantScopeFrom(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	err = r.inTx(ctx, func(tx *sql.Tx) error {
+		if issue.ID != 0 {
+			condition, args := scope.and([]interface{}{issue.ID})
+			err = traced(tx).QueryRowContext(ctx, "SELECT id, tenant_id, issue_jira_id, issue_jira_key FROM issues WHERE id=$1"+condition+" FOR UPDATE",
+				args...).Scan(&current.ID, &current.TenantID, &current.IssueJiraID, &current.IssueJiraKey)
+		} else {
+			condition, args := scope.and([]interface{}{issue.IssueJiraID})
+			err = traced(tx).QueryRowContext(ctx, "SELECT id, tenant_id, issue_jira_id, issue_jira_key FROM issues WHERE "+issueRefCondition+condition+" ORDER BY issue_jira_id=$1 DESC, id LIMIT 1 FOR UPDATE",
+				args...).Scan(&current.ID, &current.TenantID, &current.IssueJiraID, &current.IssueJiraKey)
+		}
+		if err == sql.ErrNoRows {
+			return ErrIssueNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := traced(tx).ExecContext(ctx, "UPDATE issues SET status=$1, updated_at=$2 WHERE id=$3", change.Status, now, current.ID); err != nil {
+			return err
+		}
+		return (&StepLogRepo{repo{tx: tx}}).Add(ctx, &StepLog{
+			BaseModel:     BaseModel{CreatedAt: now, UpdatedAt: now},
+			IssueID:       current.stepLogIssueID(),
+			ReporterName:  change.ReporterName,
+			SupporterName: change.SupporterName,
+			Description:   change.Description,
+			Status:        change.Status,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	issue.ID = current.ID
+	issue.TenantID = current.TenantID
+	issue.IssueJiraID = current.IssueJiraID
+	issue.IssueJiraKey = current.IssueJiraKey
+	issue.Status = change.Status
+	issue.UpdatedAt = now
+	scope.audit(ctx, "issue.update_status", *issue)
+	return nil
+}
+
+// RecordOccurrence counts a repeated report against the most recently seen
+// open issue with the given fingerprint that was last seen at or after since.
+// Issues Jira rejected are skipped, so the report opens a new ticket.
+// It bumps the occurrence count and last-seen time and adds stepLog for the
+// issue in the same transaction. It returns ErrIssueNotFound when there is no
+// such issue.
+func (r *IssueRepo) RecordOccurrence(ctx context.Context, fingerprint string, since time.Time, stepLog StepLog) (Issues, error) {
+	var issue Issues
+	scope, err := tenantScopeFrom(ctx)
+	if err != nil {
+		return issue, err
+	}
+	err = r.inTx(ctx, func(tx *sql.Tx) error {
+		condition, args := scope.and([]interface{}{fingerprint, since, pq.Array(closedStatuses)})
+		if err := scanIssue(traced(tx).QueryRowContext(ctx, "SELECT "+issueColumns+" FROM issues WHERE fingerprint=$1 AND last_seen_at >= $2 AND upper(status) <> ALL($3) AND sync_state <> '"+syncStateFailed+"'"+condition+" ORDER BY last_seen_at DESC LIMIT 1 FOR UPDATE",
+			args...), &issue); err != nil {
+			return err
+		}
+		scope.audit(ctx, "issue.record_occurrence", issue)
+		now := time.Now()
+		if err := traced(tx).QueryRowContext(ctx, "UPDATE issues SET occurrence_count=occurrence_count+1, last_seen_at=$1, updated_at=$1 WHERE id=$2 RETURNING occurrence_count",
+			now, issue.ID).Scan(&issue.OccurrenceCount); err != nil {
+			return err
+		}
+		issue.LastSeenAt = now
+		issue.UpdatedAt = now
+
+		stepLog.IssueID = issue.stepLogIssueID()
+		stepLog.Status = issue.Status
+		stepLog.CreatedAt, stepLog.UpdatedAt = now, now
+		return (&StepLogRepo{repo{tx: tx}}).Add(ctx, &stepLog)
+	})
+	return issue, err
+}
+
+// SetJiraID stores the tracker id and key of issue, found by its primary key,
+// and marks it synced. It returns ErrIssueNotFound when the issue does not
+// exist.
+func (r *IssueRepo) SetJiraID(ctx context.Context, issue *Issues, created *CreatedIssue) (int64, error) {
+	scope, err := tenantScopeFrom(ctx)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	condition, args := scope.and([]interface{}{created.ID, created.Key, syncStateSynced, now, issue.ID})
+	res, err := r.q().ExecContext(ctx, "UPDATE issues SET issue_jira_id=$1, issue_jira_key=$2, sync_state=$3, updated_at=$4 WHERE id=$5"+condition, args...)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		return 0, ErrIssueNotFound
+	}
+	issue.IssueJiraID = created.ID
+	issue.IssueJiraKey = created.Key
+	issue.SyncState = syncStateSynced
+	issue.UpdatedAt = now
+	scope.audit(ctx, "issue.set_jira_id", *issue)
+	return affected, nil
+}
+
+// MarkPending marks issue, found by its primary key, as pending so that the
+// outbox files it in Jira again, and loads it. It returns ErrIssueNotFound
+// when the issue does not exist, and ErrIssueInJira or ErrIssueQueued, with
+// issue loaded, when it has a Jira id or is pending already.
+func (r *IssueRepo) MarkPending(ctx context.Context, issue *Issues) error {
+	var current Issues
+	scope, err := tenantScopeFrom(ctx)
+	if err != nil {
+		return err
+	}
+	err = r.inTx(ctx, func(tx *sql.Tx) error {
+		condition, args := scope.and([]interface{}{issue.ID})
+		if err := scanIssue(traced(tx).QueryRowContext(ctx, "SELECT "+issueColumns+" FROM issues WHERE id=$1"+condition+" FOR UPDATE", args...), &current); err != nil {
+			return err
+		}
+		switch {
+		case current.IssueJiraID != "":
+			return ErrIssueInJira
+		case current.SyncState == syncStatePending:
+			return ErrIssueQueued
+		}
+		current.SyncState = syncStatePending
+		current.UpdatedAt = time.Now()
+		_, err := traced(tx).ExecContext(ctx, "UPDATE issues SET sync_state=$1, updated_at=$2 WHERE id=$3", current.SyncState, current.UpdatedAt, current.ID)
+		return err
+	})
+	if errors.Is(err, ErrIssueInJira) || errors.Is(err, ErrIssueQueued) {
+		*issue = current
+	}
+	if err != nil {
+		return err
+	}
+	*issue = current
+	scope.audit(ctx, "issue.mark_pending", current)
+	return nil
+}
+
+// UpdateFields applies the fields set in update to issue, found by its
+// primary key, and records a status change in step_log in the same
+// transaction. It returns ErrIssueNotFound when the issue does not exist and
+// a *TransitionError when the workflow does not allow the new status.
+func (r *IssueRepo) UpdateFields(ctx context.Context, issue *Issues, update IssueUpdateRequest, actor string) error {
+	var current Issues
+	scope, err := tenantScopeFrom(ctx)
+	if err != nil {
+		return err
+	}
+	err = r.inTx(ctx, func(tx *sql.Tx) error {
+		condition, args := scope.and([]interface{}{issue.ID})
+		if err := scanIssue(traced(tx).QueryRowContext(ctx, "SELECT "+issueColumns+" FROM issues WHERE id=$1"+condition+" FOR UPDATE", args...), &current); err != nil {
+			return err
+		}
+		previousStatus := current.Status
+		if update.Status != nil {
+			status := normalizeStatus(*update.Status)
+			if err := checkTransition(current.Status, status); err != nil {
+				return err
+			}
+			if !strings.EqualFold(status, current.Status) {
+				current.Status = status
+			}
+		}
+		if update.Name != nil {
+			current.Name = *update.Name
+		}
+		if update.Service != nil {
+			current.Service = *update.Service
+		}
+		if update.Assignee != nil {
+			current.Assignee = *update.Assignee
+		}
+		if update.Priority != nil {
+			current.Priority = *update.Priority
+		}
+		current.UpdatedAt = time.Now()
+
+		if _, err := traced(tx).ExecContext(ctx, "UPDATE issues SET status=$1, name=$2, service=$3, assignee=$4, priority=$5, updated_at=$6 WHERE id=$7",
+			current.Status, current.Name, current.Service, current.Assignee, current.Priority, current.UpdatedAt, current.ID); err != nil {
+			return err
+		}
+		if current.Status == previousStatus {
+			return nil
+		}
+		return (&StepLogRepo{repo{tx: tx}}).Add(ctx, &StepLog{
+			BaseModel:     BaseModel{CreatedAt: current.UpdatedAt, UpdatedAt: current.UpdatedAt},
+			IssueID:       current.stepLogIssueID(),
+			ReporterName:  actor,
+			SupporterName: actor,
+			Description:   fmt.Sprintf("Status changed from %s to %s", previousStatus, current.Status),
+			Status:        current.Status,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	*issue = current
+	scope.audit(ctx, "issue.update", current)
+	return nil
+}
+
+// Delete removes the issue with the given Jira id or key and returns
+// ErrIssueNotFound when there was none.
+func (r *IssueRepo) Delete(ctx context.Context, issueJiraRef string) error {
+	if issueJiraRef == "" {
+		return ErrIssueNotFound
+	}
+	scope, err := tenantScopeFrom(ctx)
+	if err != nil {
+		return err
+	}
+	var issue Issues
+	condition, args := scope.and([]interface{}{issueJiraRef})
+	err = r.q().QueryRowContext(ctx, "DELETE FROM issues WHERE id=(SELECT id FROM issues WHERE "+issueRefCondition+condition+" ORDER BY issue_jira_id=$1 DESC, id LIMIT 1) RETURNING id, tenant_id",
+		args...).Scan(&issue.ID, &issue.TenantID)
+	if err == sql.ErrNoRows {
+		return ErrIssueNotFound
+	}
+	if err != nil {
+		return err
+	}
+	scope.audit(ctx, "issue.delete", issue)
+	return nil
+}
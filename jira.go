@@ -0,0 +1,244 @@
+// jira.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// JiraTracker implements Tracker against the Jira REST API v2.
+type JiraTracker struct {
+	BaseURL     string
+	Username    string
+	Token       string
+	CloseStatus string
+	Client      *TrackerClient
+}
+
+func NewJiraTracker(baseURL, username, token string) *JiraTracker {
+	return &JiraTracker{
+		BaseURL:     strings.TrimRight(baseURL, "/"),
+		Username:    username,
+		Token:       token,
+		CloseStatus: "Done",
+		Client:      NewTrackerClient(),
+	}
+}
+
+// JiraError is returned when Jira answers with a non-2xx status or with an
+// error payload.
+type JiraError struct {
+	StatusCode    int               `json:"-"`
+	ErrorMessages []string          `json:"errorMessages"`
+	Errors        map[string]string `json:"errors"`
+}
+
+func (e *JiraError) Error() string {
+	messages := append([]string{}, e.ErrorMessages...)
+	fields := make([]string, 0, len(e.Errors))
+	for field := range e.Errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		messages = append(messages, field+": "+e.Errors[field])
+	}
+	if len(messages) == 0 {
+		return fmt.Sprintf("Jira returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("Jira returned status %d: %s", e.StatusCode, strings.Join(messages, "; "))
+}
+
+// newJiraError builds a JiraError from a response body, which Jira fills
+// with errorMessages and errors when it rejects a request.
+func newJiraError(statusCode int, body []byte) *JiraError {
+	jiraErr := &JiraError{StatusCode: statusCode}
+	if err := json.Unmarshal(body, jiraErr); err != nil {
+		jiraErr.ErrorMessages = []string{strings.TrimSpace(string(body))}
+	}
+	return jiraErr
+}
+
+type jiraTransitions struct {
+	Transitions []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		To   struct {
+			Name string `json:"name"`
+		} `json:"to"`
+	} `json:"transitions"`
+}
+
+// do sends a request to the Jira API through the shared tracker client and
+// decodes a JSON response into out when out is not nil.
+func (t *JiraTracker) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var data []byte
+	if in != nil {
+		var err error
+		if data, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	statusCode, body, err := t.Client.Do(ctx, func(ctx context.Context) (*http.Request, error) {
+		var payload io.Reader
+		if data != nil {
+			payload = bytes.NewReader(data)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, t.BaseURL+path, payload)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create request to Jira: [%s]", err.Error())
+		}
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Accept", "application/json")
+		if id := requestIDFrom(ctx); id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+		if t.Username != "" {
+			req.SetBasicAuth(t.Username, t.Token)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	if statusCode < 200 || statusCode > 299 {
+		return newJiraError(statusCode, body)
+	}
+	if out == nil || len(body) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("Unable to Unmarshal response body from Jira: [%s]", err.Error())
+	}
+	return nil
+}
+
+type jiraSearchResult struct {
+	Issues []struct {
+		ID   string `json:"id"`
+		Key  string `json:"key"`
+		Self string `json:"self"`
+	} `json:"issues"`
+}
+
+// findByLabel returns the first issue carrying label, or nil when there is
+// none.
+func (t *JiraTracker) findByLabel(ctx context.Context, label string) (*CreatedIssue, error) {
+	query := url.Values{}
+	query.Set("jql", fmt.Sprintf("labels = %q", label))
+	query.Set("fields", "key")
+	query.Set("maxResults", "1")
+	var result jiraSearchResult
+	if err := t.do(ctx, "GET", "/rest/api/2/search?"+query.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Issues) == 0 {
+		return nil, nil
+	}
+	found := result.Issues[0]
+	return &CreatedIssue{ID: found.ID, Key: found.Key, Self: found.Self}, nil
+}
+
+// CreateIssue creates issue in Jira. An idempotency key is stored as a label
+// and searched for first, so a retried create finds the earlier issue.
+func (t *JiraTracker) CreateIssue(ctx context.Context, issue TrackerIssue) (*CreatedIssue, error) {
+	labels := issue.Labels
+	if issue.IdempotencyKey != "" {
+		label := idempotencyLabel(issue.IdempotencyKey)
+		existing, err := t.findByLabel(ctx, label)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return existing, nil
+		}
+		labels = append(append([]string(nil), labels...), label)
+	}
+
+	fields := map[string]interface{}{
+		"project":     map[string]string{"id": issue.ProjectID},
+		"issuetype":   map[string]string{"id": issue.IssueType},
+		"summary":     issue.Summary,
+		"description": issue.Description,
+		"environment": issue.Environment,
+	}
+	if issue.Assignee != "" {
+		fields["assignee"] = map[string]string{"name": issue.Assignee}
+	}
+	if issue.Priority != "" {
+		fields["priority"] = map[string]string{"name": issue.Priority}
+	}
+	if len(labels) > 0 {
+		fields["labels"] = labels
+	}
+	if issue.Reporter != "" {
+		fields["reporter"] = map[string]string{"name": issue.Reporter}
+	}
+
+	var responseJira ResponseJira
+	if err := t.do(ctx, "POST", "/rest/api/2/issue", map[string]interface{}{"fields": fields}, &responseJira); err != nil {
+		return nil, err
+	}
+	if len(responseJira.ErrorMessages) > 0 || len(responseJira.Errors) > 0 {
+		return nil, &JiraError{
+			StatusCode:    http.StatusBadRequest,
+			ErrorMessages: responseJira.ErrorMessages,
+			Errors:        responseJira.Errors,
+		}
+	}
+	if responseJira.Id == "" || responseJira.Key == "" {
+		return nil, fmt.Errorf("Jira did not return an id and key for the created issue")
+	}
+	return &CreatedIssue{ID: responseJira.Id, Key: responseJira.Key, Self: responseJira.Self}, nil
+}
+
+func (t *JiraTracker) GetStatus(ctx context.Context, issueID string) (string, error) {
+	var issueResponse IssueResponse
+	if err := t.do(ctx, "GET", "/rest/api/2/issue/"+url.PathEscape(issueID)+"?fields=status", nil, &issueResponse); err != nil {
+		return "", err
+	}
+	return issueResponse.Fields.Status.Name, nil
+}
+
+func (t *JiraTracker) AddComment(ctx context.Context, issueID, body string) error {
+	return t.do(ctx, "POST", "/rest/api/2/issue/"+url.PathEscape(issueID)+"/comment", map[string]string{"body": body}, nil)
+}
+
+// Transition moves the issue to status using the first available workflow
+// transition whose name or target status matches.
+func (t *JiraTracker) Transition(ctx context.Context, issueID, status string) error {
+	path := "/rest/api/2/issue/" + url.PathEscape(issueID) + "/transitions"
+	var transitions jiraTransitions
+	if err := t.do(ctx, "GET", path, nil, &transitions); err != nil {
+		return err
+	}
+	for _, transition := range transitions.Transitions {
+		if strings.EqualFold(transition.To.Name, status) || strings.EqualFold(transition.Name, status) {
+			return t.do(ctx, "POST", path, map[string]interface{}{
+				"transition": map[string]string{"id": transition.ID},
+			}, nil)
+		}
+	}
+	return fmt.Errorf("No transition to status %q is available for issue %s", status, issueID)
+}
+
+func (t *JiraTracker) Close(ctx context.Context, issueID string) error {
+	return t.Transition(ctx, issueID, t.CloseStatus)
+}
+
+func (t *JiraTracker) Health() TrackerHealth {
+	breaker := t.Client.Breaker.Status()
+	return TrackerHealth{Tracker: "jira", BaseURL: t.BaseURL, Breaker: &breaker}
+}
+
+func (t *JiraTracker) Ping(ctx context.Context) error {
+	return t.do(ctx, "GET", "/rest/api/2/myself", nil, nil)
+}
@@ -0,0 +1,285 @@
+// jwt.go
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwtLeeway is the clock skew allowed when checking exp and nbf.
+const jwtLeeway = time.Minute
+
+// JWTVerifier checks bearer tokens signed with HS256 by a shared secret or
+// with RS256 by a key from a JWKS file. The JWKS file is read again when a
+// token names a key it does not have, so keys can be rotated without a
+// restart.
+type JWTVerifier struct {
+	Issuer      string
+	Audience    string
+	TenantClaim string
+	RolesClaim  string
+
+	hmacSecret []byte
+	jwksFile   string
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	jwksModTime time.Time
+}
+
+// NewJWTVerifier returns nil when config allows neither HS256 nor RS256.
+func NewJWTVerifier(config JWTConfig) (*JWTVerifier, error) {
+	if config.HMACSecret == "" && config.JWKSFile == "" {
+		return nil, nil
+	}
+	v := &JWTVerifier{
+		Issuer:      config.Issuer,
+		Audience:    config.Audience,
+		TenantClaim: config.TenantClaim,
+		RolesClaim:  config.RolesClaim,
+		hmacSecret:  []byte(config.HMACSecret),
+		jwksFile:    config.JWKSFile,
+	}
+	if v.jwksFile != "" {
+		if err := v.loadJWKS(); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the signature, issuer, audience and lifetime of token and
+// returns who it was issued to. Every error wraps ErrUnauthenticated.
+func (v *JWTVerifier) Verify(token string, now time.Time) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrUnauthenticated)
+	}
+	if err := v.verifySignature(header, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := v.checkClaims(claims, now); err != nil {
+		return nil, err
+	}
+	principal := &Principal{Method: authMethodJWT}
+	principal.Subject, _ = claims["sub"].(string)
+	principal.TenantID, _ = claims[v.TenantClaim].(string)
+	principal.Roles = stringsClaim(claims[v.RolesClaim])
+	for _, claim := range []string{"preferred_username", "name", "sub"} {
+		if principal.Name, _ = claims[claim].(string); principal.Name != "" {
+			break
+		}
+	}
+	if principal.Subject == "" {
+		return nil, fmt.Errorf("%w: token has no subject", ErrUnauthenticated)
+	}
+	return principal, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	return nil
+}
+
+func (v *JWTVerifier) verifySignature(header jwtHeader, signed string, signature []byte) error {
+	switch {
+	case header.Alg == "HS256" && len(v.hmacSecret) > 0:
+		mac := hmac.New(sha256.New, v.hmacSecret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return fmt.Errorf("%w: invalid signature", ErrUnauthenticated)
+		}
+		return nil
+	case header.Alg == "RS256" && v.jwksFile != "":
+		key, err := v.key(header.Kid)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256([]byte(signed))
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return fmt.Errorf("%w: invalid signature", ErrUnauthenticated)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported signing algorithm %q", ErrUnauthenticated, header.Alg)
+	}
+}
+
+func (v *JWTVerifier) checkClaims(claims map[string]interface{}, now time.Time) error {
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return fmt.Errorf("%w: token has no expiry", ErrUnauthenticated)
+	}
+	if now.After(exp.Add(jwtLeeway)) {
+		return fmt.Errorf("%w: token expired", ErrUnauthenticated)
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(jwtLeeway).Before(nbf) {
+		return fmt.Errorf("%w: token not valid yet", ErrUnauthenticated)
+	}
+	if issuer, _ := claims["iss"].(string); v.Issuer != "" && issuer != v.Issuer {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrUnauthenticated, issuer)
+	}
+	if v.Audience != "" && !hasAudience(claims["aud"], v.Audience) {
+		return fmt.Errorf("%w: token is not for audience %q", ErrUnauthenticated, v.Audience)
+	}
+	return nil
+}
+
+func numericDate(claim interface{}) (time.Time, bool) {
+	number, ok := claim.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), true
+}
+
+// hasAudience accepts aud as a single string or a list of strings.
+func hasAudience(claim interface{}, audience string) bool {
+	switch aud := claim.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stringsClaim reads a claim given as a list of strings or as one string of
+// space or comma separated values, like scope.
+func stringsClaim(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return splitRoles(value)
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// key returns the RSA key with the given kid. A token without a kid may be
+// verified when the JWKS holds a single key.
+func (v *JWTVerifier) key(kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	lookup := func() *rsa.PublicKey {
+		if kid == "" && len(v.keys) == 1 {
+			for _, key := range v.keys {
+				return key
+			}
+		}
+		return v.keys[kid]
+	}
+	if key := lookup(); key != nil {
+		return key, nil
+	}
+	if info, err := os.Stat(v.jwksFile); err == nil && !info.ModTime().Equal(v.jwksModTime) {
+		if err := v.readJWKS(); err != nil {
+			return nil, err
+		}
+		if key := lookup(); key != nil {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrUnauthenticated, kid)
+}
+
+func (v *JWTVerifier) loadJWKS() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.readJWKS()
+}
+
+// readJWKS replaces the keys with the RSA signing keys of the JWKS file. It
+// is called with mu held.
+func (v *JWTVerifier) readJWKS() error {
+	info, err := os.Stat(v.jwksFile)
+	if err != nil {
+		return fmt.Errorf("Unable to read JWKS file: [%s]", err.Error())
+	}
+	data, err := os.ReadFile(v.jwksFile)
+	if err != nil {
+		return fmt.Errorf("Unable to read JWKS file: [%s]", err.Error())
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return fmt.Errorf("Invalid JWKS file %s: %w", v.jwksFile, err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			return fmt.Errorf("Invalid JWKS file %s: key %q is malformed", v.jwksFile, jwk.Kid)
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("Invalid JWKS file %s: no RSA signing keys", v.jwksFile)
+	}
+	v.keys = keys
+	v.jwksModTime = info.ModTime()
+	return nil
+}
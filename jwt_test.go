@@ -0,0 +1,245 @@
+// jwt_test.go
+
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testHMACSecret = "0123456789abcdef0123456789abcdef"
+
+func signHS256(t *testing.T, header, claims map[string]interface{}, secret []byte) string {
+	t.Helper()
+	signed := encodeJWTPart(t, header) + "." + encodeJWTPart(t, claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(t *testing.T, header, claims map[string]interface{}, key *rsa.PrivateKey) string {
+	t.Helper()
+	signed := encodeJWTPart(t, header) + "." + encodeJWTPart(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeJWTPart(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func writeJWKS(t *testing.T, kid string, key *rsa.PublicKey) string {
+	t.Helper()
+	jwks := map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestJWTVerifierVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := JWTConfig{
+		Issuer:      "https://sso.example.com",
+		Audience:    "hickathon",
+		HMACSecret:  testHMACSecret,
+		JWKSFile:    writeJWKS(t, "k1", &key.PublicKey),
+		TenantClaim: "tenant_id",
+		RolesClaim:  "roles",
+	}
+	verifier, err := NewJWTVerifier(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaOnly := config
+	rsaOnly.HMACSecret = ""
+	rsaVerifier, err := NewJWTVerifier(rsaOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacOnly := config
+	hmacOnly.JWKSFile = ""
+	hmacVerifier, err := NewJWTVerifier(hmacOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":       "user-1",
+			"name":      "Jane",
+			"iss":       config.Issuer,
+			"aud":       config.Audience,
+			"exp":       now.Add(time.Hour).Unix(),
+			"tenant_id": "t1",
+			"roles":     []string{"reporter", "supporter"},
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	hs := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	rs := map[string]interface{}{"alg": "RS256", "kid": "k1"}
+	publicKeyBytes := key.PublicKey.N.Bytes()
+
+	tests := []struct {
+		name     string
+		verifier *JWTVerifier
+		token    string
+		want     *Principal
+	}{
+		{
+			name:     "valid HS256",
+			verifier: verifier,
+			token:    signHS256(t, hs, claims(nil), []byte(testHMACSecret)),
+			want:     &Principal{Subject: "user-1", Name: "Jane", TenantID: "t1", Roles: []string{"reporter", "supporter"}, Method: authMethodJWT},
+		},
+		{
+			name:     "valid RS256",
+			verifier: verifier,
+			token:    signRS256(t, rs, claims(nil), key),
+			want:     &Principal{Subject: "user-1", Name: "Jane", TenantID: "t1", Roles: []string{"reporter", "supporter"}, Method: authMethodJWT},
+		},
+		{
+			name:     "roles as a space separated string",
+			verifier: verifier,
+			token:    signHS256(t, hs, claims(map[string]interface{}{"roles": "admin operator"}), []byte(testHMACSecret)),
+			want:     &Principal{Subject: "user-1", Name: "Jane", TenantID: "t1", Roles: []string{"admin", "operator"}, Method: authMethodJWT},
+		},
+		{
+			name:     "audience in a list",
+			verifier: verifier,
+			token:    signHS256(t, hs, claims(map[string]interface{}{"aud": []string{"other", "hickathon"}}), []byte(testHMACSecret)),
+			want:     &Principal{Subject: "user-1", Name: "Jane", TenantID: "t1", Roles: []string{"reporter", "supporter"}, Method: authMethodJWT},
+		},
+		{
+			name:     "expired within the leeway",
+			verifier: verifier,
+			token:    signHS256(t, hs, claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()}), []byte(testHMACSecret)),
+			want:     &Principal{Subject: "user-1", Name: "Jane", TenantID: "t1", Roles: []string{"reporter", "supporter"}, Method: authMethodJWT},
+		},
+		{
+			name:     "expired",
+			verifier: verifier,
+			token:    signHS256(t, hs, claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()}), []byte(testHMACSecret)),
+		},
+		{
+			name:     "no expiry",
+			verifier: verifier,
+			token:    signHS256(t, hs, claims(map[string]interface{}{"exp": nil}), []byte(testHMACSecret)),
+		},
+		{
+			name:     "not valid yet",
+			verifier: verifier,
+			token:    signHS256(t, hs, claims(map[string]interface{}{"nbf": now.Add(2 * time.Minute).Unix()}), []byte(testHMACSecret)),
+		},
+		{
+			name:     "audience list without ours",
+			verifier: verifier,
+			token:    signHS256(t, hs, claims(map[string]interface{}{"aud": []string{"other"}}), []byte(testHMACSecret)),
+		},
+		{
+			name:     "wrong audience",
+			verifier: verifier,
+			token:    signHS256(t, hs, claims(map[string]interface{}{"aud": "other"}), []byte(testHMACSecret)),
+		},
+		{
+			name:     "wrong issuer",
+			verifier: verifier,
+			token:    signHS256(t, hs, claims(map[string]interface{}{"iss": "https://evil.example.com"}), []byte(testHMACSecret)),
+		},
+		{
+			name:     "no subject",
+			verifier: verifier,
+			token:    signHS256(t, hs, claims(map[string]interface{}{"sub": nil}), []byte(testHMACSecret)),
+		},
+		{
+			name:     "wrong secret",
+			verifier: verifier,
+			token:    signHS256(t, hs, claims(nil), []byte("another secret of at least 32 characters")),
+		},
+		{
+			name:     "alg none",
+			verifier: verifier,
+			token:    encodeJWTPart(t, map[string]interface{}{"alg": "none"}) + "." + encodeJWTPart(t, claims(nil)) + ".",
+		},
+		{
+			name:     "HS256 signed with the RSA public key",
+			verifier: rsaVerifier,
+			token:    signHS256(t, hs, claims(nil), publicKeyBytes),
+		},
+		{
+			name:     "RS256 without a JWKS file",
+			verifier: hmacVerifier,
+			token:    signRS256(t, rs, claims(nil), key),
+		},
+		{
+			name:     "unknown kid",
+			verifier: verifier,
+			token:    signRS256(t, map[string]interface{}{"alg": "RS256", "kid": "k2"}, claims(nil), key),
+		},
+		{
+			name:     "malformed",
+			verifier: verifier,
+			token:    "not.a-token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.verifier.Verify(tt.token, now)
+			if tt.want == nil {
+				if !errors.Is(err, ErrUnauthenticated) {
+					t.Fatalf("Verify() = %v, %v; want an ErrUnauthenticated error", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Verify() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+// logging.go
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// redactedLogKeys are attribute keys whose values are never written to the
+// log: issue content and the people involved, and credentials.
+var redactedLogKeys = map[string]bool{
+	"content":      true,
+	"datalog":      true,
+	"description":  true,
+	"reporter":     true,
+	"reportername": true,
+	"password":     true,
+	"token":        true,
+	"secret":       true,
+}
+
+// newLogger returns a JSON logger writing to w at the given level (debug,
+// info, warn or error; info when empty) that adds the request ID found in the
+// context and redacts redactedLogKeys.
+func newLogger(w io.Writer, level string) (*slog.Logger, error) {
+	var l slog.Level
+	if level != "" {
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("Invalid log level %q, expected debug, info, warn or error", level)
+		}
+	}
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level: l,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if redactedLogKeys[strings.ToLower(attr.Key)] {
+				return slog.String(attr.Key, "[redacted]")
+			}
+			return attr
+		},
+	})
+	return slog.New(requestIDHandler{handler}), nil
+}
+
+// requestIDHandler adds the request ID of the record's context, if any.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := requestIDFrom(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+type requestIDKey struct{}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDPattern limits client supplied request IDs to characters that are
+// safe to echo in headers, logs and SQL comments.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// routeTemplate is the mux path template of the matched route, which unlike
+// the path has a bounded number of values.
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return "unmatched"
+}
+
+// requestIDMiddleware gives every request an ID, taken from X-Request-ID
+// when the client sent a usable one, and returns it in the response. Once the
+// request is done it is logged and counted in the HTTP metrics.
+func (a *App) requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := withRequestID(r.Context(), id)
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		duration := time.Since(start)
+		appMetrics.observeHTTP(r.Method, routeTemplate(r), recorder.status, duration)
+		level := slog.LevelInfo
+		if recorder.status >= 500 {
+			level = slog.LevelError
+		}
+		a.Logger.Log(ctx, level, "request",
+			"method", r.Method,
+			"route", routeTemplate(r),
+			"status", recorder.status,
+			"duration_ms", duration.Milliseconds())
+	})
+}
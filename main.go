@@ -3,16 +3,115 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	a := App{}
-	a.Initialize(
-		os.Getenv("APP_DB_USERNAME"),
-		os.Getenv("APP_DB_PASSWORD"),
-		os.Getenv("APP_DB_NAME"))
-	fmt.Println("Initialize DB connection")
-	a.Run(":8010")
+	config, args, err := LoadConfig(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if len(args) > 0 && args[0] == "config" {
+		if err := runConfigCommand(os.Stdout, config, args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := config.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger, err := newLogger(os.Stdout, config.LogLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+	fatal := func(msg string, err error) {
+		logger.Error(msg, "error", err.Error())
+		os.Exit(1)
+	}
+
+	routing, err := NewRoutingTable(config.RoutingFile)
+	if err != nil {
+		fatal("unable to load routing rules", err)
+	}
+	a := App{
+		Logger:        logger,
+		Server:        config.Server,
+		Tracker:       newTracker(config.Tracker),
+		Routing:       routing,
+		WebhookSecret: config.Tracker.Jira.WebhookSecret,
+
+		UnknownErrorCodes:     config.Issues.UnknownErrorCodes,
+		DedupWindow:           config.Issues.DedupWindow,
+		ReadyTimeout:          config.Ready.Timeout,
+		ReadyCheckTracker:     config.Ready.CheckTracker,
+		RoutingReloadInterval: config.Workers.RoutingReloadInterval,
+		PolicyReloadInterval:  config.Workers.PolicyReloadInterval,
+		CORS:                  config.CORS,
+	}
+	if a.Policy, err = NewPolicy(config.Auth.PolicyFile); err != nil {
+		fatal("unable to load policy", err)
+	}
+	if config.Auth.Enabled {
+		verifier, err := NewJWTVerifier(config.Auth.JWT)
+		if err != nil {
+			fatal("unable to load JWT keys", err)
+		}
+		a.Auth = &Authenticator{JWT: verifier}
+	} else {
+		logger.Warn("authentication is disabled")
+	}
+	if err := a.Initialize(config.Database.ConnectionString()); err != nil {
+		fatal("unable to open database", err)
+	}
+	a.Sync.Interval = config.Workers.SyncInterval
+	a.Outbox.Interval = config.Workers.OutboxInterval
+	logger.Info("initialized DB connection")
+
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrateCommand(a.DB, args[1:]); err != nil {
+			fatal("migrate failed", err)
+		}
+		return
+	}
+	if len(args) > 0 && args[0] == "apikey" {
+		if err := runAPIKeyCommand(os.Stdout, a.APIKeyRepo, args[1:]); err != nil {
+			fatal("apikey failed", err)
+		}
+		return
+	}
+	if len(args) > 0 {
+		fatal("unknown command", fmt.Errorf("%q is not a command; expected migrate, apikey or config", args[0]))
+	}
+
+	migrator, err := NewMigrator(a.DB)
+	if err != nil {
+		fatal("unable to load migrations", err)
+	}
+	applied, err := migrator.Up(context.Background())
+	if err != nil {
+		fatal("unable to apply migrations", err)
+	}
+	logger.Info("applied migrations", "count", len(applied))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	if err := a.Run(ctx); err != nil {
+		fatal("server failed", err)
+	}
 }
@@ -0,0 +1,289 @@
+// metrics.go
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histograms.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// appMetrics collects the counters and histograms served at GET /metrics.
+// Gauges that can be read on demand, such as the DB pool and sync lag, are
+// computed when scraped.
+var appMetrics = NewMetrics()
+
+// Metrics is a minimal registry of labelled counters and histograms written
+// in the Prometheus text exposition format.
+type Metrics struct {
+	mu         sync.Mutex
+	counters   map[string]*metricFamily
+	histograms map[string]*metricFamily
+}
+
+type metricFamily struct {
+	help   string
+	labels []string
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// Histograms only.
+	buckets []uint64
+	count   uint64
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{counters: map[string]*metricFamily{}, histograms: map[string]*metricFamily{}}
+	m.counters["http_requests_total"] = newFamily("HTTP requests handled, by mux route template.", "method", "route", "status")
+	m.histograms["http_request_duration_seconds"] = newFamily("Latency of HTTP requests, by mux route template.", "method", "route")
+	m.counters["issues_created_total"] = newFamily("Issues filed in the tracker, by routing rule and project.", "rule", "project")
+	m.histograms["tracker_request_duration_seconds"] = newFamily("Latency of tracker API calls, including failed ones.", "method", "path")
+	m.counters["tracker_request_errors_total"] = newFamily("Tracker API calls that failed, by reason.", "method", "path", "reason")
+	return m
+}
+
+func newFamily(help string, labels ...string) *metricFamily {
+	return &metricFamily{help: help, labels: labels, series: map[string]*series{}}
+}
+
+func (f *metricFamily) get(labelValues []string, histogram bool) *series {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: labelValues}
+		if histogram {
+			s.buckets = make([]uint64, len(latencyBuckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (m *Metrics) inc(name string, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[name].get(labelValues, false).value++
+}
+
+func (m *Metrics) observe(name string, d time.Duration, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.histograms[name].get(labelValues, true)
+	seconds := d.Seconds()
+	s.value += seconds
+	s.count++
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			s.buckets[i]++
+		}
+	}
+}
+
+func (m *Metrics) observeHTTP(method, route string, status int, d time.Duration) {
+	m.inc("http_requests_total", method, route, strconv.Itoa(status))
+	m.observe("http_request_duration_seconds", d, method, route)
+}
+
+func (m *Metrics) observeTracker(method, path string, statusCode int, err error, d time.Duration) {
+	path = trackerPathTemplate(path)
+	m.observe("tracker_request_duration_seconds", d, method, path)
+	switch {
+	case err != nil:
+		m.inc("tracker_request_errors_total", method, path, "transport")
+	case statusCode == http.StatusTooManyRequests || statusCode >= 500:
+		m.inc("tracker_request_errors_total", method, path, strconv.Itoa(statusCode))
+	}
+}
+
+// issueCreated counts an issue filed in the tracker. Both labels come from
+// the routing rules rather than from clients, which keeps the number of
+// series bounded.
+func (m *Metrics) issueCreated(rule, projectID string) {
+	m.inc("issues_created_total", rule, projectID)
+}
+
+// trackerPathTemplate replaces issue ids and keys in a tracker API path so
+// that the path can be used as a label.
+func trackerPathTemplate(path string) string {
+	parts := strings.Split(path, "/")
+	for i := 1; i < len(parts); i++ {
+		if parts[i-1] == "issue" && parts[i] != "" {
+			parts[i] = "{id}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// gauge is a value computed at scrape time. Cumulative values read from
+// elsewhere, such as sql.DBStats, are gauges with counter set.
+type gauge struct {
+	name    string
+	help    string
+	counter bool
+	labels  []string
+	values  []gaugeValue
+}
+
+type gaugeValue struct {
+	labelValues []string
+	value       float64
+}
+
+// WriteTo writes every metric, followed by gauges, in the text exposition
+// format.
+func (m *Metrics) WriteTo(w io.Writer, gauges []gauge) error {
+	m.mu.Lock()
+	var b strings.Builder
+	for _, name := range sortedKeys(m.counters) {
+		f := m.counters[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, f.help, name)
+		for _, s := range f.sorted() {
+			fmt.Fprintf(&b, "%s%s %s\n", name, formatLabels(f.labels, s.labelValues), formatFloat(s.value))
+		}
+	}
+	for _, name := range sortedKeys(m.histograms) {
+		f := m.histograms[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s histogram\n", name, f.help, name)
+		for _, s := range f.sorted() {
+			labels := append(append([]string(nil), f.labels...), "le")
+			for i, bound := range latencyBuckets {
+				values := append(append([]string(nil), s.labelValues...), formatFloat(bound))
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", name, formatLabels(labels, values), s.buckets[i])
+			}
+			values := append(append([]string(nil), s.labelValues...), "+Inf")
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", name, formatLabels(labels, values), s.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", name, formatLabels(f.labels, s.labelValues), formatFloat(s.value))
+			fmt.Fprintf(&b, "%s_count%s %d\n", name, formatLabels(f.labels, s.labelValues), s.count)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, g := range gauges {
+		kind := "gauge"
+		if g.counter {
+			kind = "counter"
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", g.name, g.help, g.name, kind)
+		for _, v := range g.values {
+			fmt.Fprintf(&b, "%s%s %s\n", g.name, formatLabels(g.labels, v.labelValues), formatFloat(v.value))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (f *metricFamily) sorted() []*series {
+	keys := sortedKeys(f.series)
+	sorted := make([]*series, 0, len(keys))
+	for _, key := range keys {
+		sorted = append(sorted, f.series[key])
+	}
+	return sorted
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// scrapeGauges reads the DB pool statistics and the sync lag of every open
+// issue in scope that the sync worker tracks.
+func (a *App) scrapeGauges(now time.Time, scope tenantScope) []gauge {
+	stats := a.DB.Stats()
+	single := func(name, help string, value float64) gauge {
+		return gauge{name: name, help: help, values: []gaugeValue{{value: value}}}
+	}
+	counter := func(name, help string, value float64) gauge {
+		g := single(name, help, value)
+		g.counter = true
+		return g
+	}
+	gauges := []gauge{
+		single("db_max_open_connections", "Maximum number of open connections to the database.", float64(stats.MaxOpenConnections)),
+		single("db_open_connections", "Established connections, in use and idle.", float64(stats.OpenConnections)),
+		single("db_in_use_connections", "Connections currently in use.", float64(stats.InUse)),
+		single("db_idle_connections", "Idle connections.", float64(stats.Idle)),
+		counter("db_wait_count_total", "Connections waited for.", float64(stats.WaitCount)),
+		counter("db_wait_duration_seconds_total", "Time blocked waiting for a new connection.", stats.WaitDuration.Seconds()),
+		counter("db_max_idle_closed_total", "Connections closed due to SetMaxIdleConns.", float64(stats.MaxIdleClosed)),
+		counter("db_max_idle_time_closed_total", "Connections closed due to SetConnMaxIdleTime.", float64(stats.MaxIdleTimeClosed)),
+		counter("db_max_lifetime_closed_total", "Connections closed due to SetConnMaxLifetime.", float64(stats.MaxLifetimeClosed)),
+	}
+
+	lag := gauge{
+		name:   "issue_sync_lag_seconds",
+		help:   "Time since each open issue was last reconciled with the tracker.",
+		labels: []string{"issue_jira_id"},
+	}
+	var tracked, neverSynced, maxLag float64
+	for _, entry := range a.Sync.Registry.List() {
+		if scope.check(entry.TenantID) != nil {
+			continue
+		}
+		tracked++
+		if entry.LastSync == nil {
+			neverSynced++
+			continue
+		}
+		seconds := now.Sub(*entry.LastSync).Seconds()
+		lag.values = append(lag.values, gaugeValue{labelValues: []string{entry.IssueJiraID}, value: seconds})
+		maxLag = math.Max(maxLag, seconds)
+	}
+	return append(gauges, lag,
+		single("issue_sync_tracked", "Open issues the sync worker tracks.", tracked),
+		single("issue_sync_lag_max_seconds", "Largest issue_sync_lag_seconds.", maxLag),
+		single("issue_sync_never_synced", "Open issues the sync worker has not reconciled yet.", neverSynced))
+}
+
+// getMetrics serves the metrics to principals granted metrics.read. The
+// per-issue sync lag only covers the issues in their tenant scope.
+func (a *App) getMetrics(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, actionMetricsRead) {
+		return
+	}
+	scope, err := tenantScopeFrom(r.Context())
+	if err != nil {
+		respondWithError(w, issueErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := appMetrics.WriteTo(w, a.scrapeGauges(time.Now(), scope)); err != nil {
+		a.Logger.ErrorContext(r.Context(), "unable to write metrics", "error", err.Error())
+	}
+}
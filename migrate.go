@@ -0,0 +1,345 @@
+// migrate.go
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the key of the Postgres advisory lock held while
+// migrating, so that only one replica applies migrations at a time.
+const migrationLockID = 7261641100
+
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Checksum  string     `json:"checksum"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+	Modified  bool       `json:"modified"`
+}
+
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+}
+
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// loadMigrations reads files named <version>_<name>.up.sql and
+// <version>_<name>.down.sql from dir and returns them ordered by version.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(fileName, ".sql") {
+			continue
+		}
+		var direction string
+		base := strings.TrimSuffix(fileName, ".sql")
+		switch {
+		case strings.HasSuffix(base, ".up"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("Migration file %s must end in .up.sql or .down.sql", fileName)
+		}
+		base = strings.TrimSuffix(base, "."+direction)
+		parts := strings.SplitN(base, "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Migration file %s must be named <version>_<name>", fileName)
+		}
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("Migration file %s has an invalid version", fileName)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		} else if m.Name != parts[1] {
+			return nil, fmt.Errorf("Migration version %d is used by both %s and %s", version, m.Name, parts[1])
+		}
+		if direction == "up" {
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("Migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+type appliedMigration struct {
+	Version   int
+	Checksum  string
+	AppliedAt time.Time
+}
+
+func ensureMigrationTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       VARCHAR(255) NOT NULL,
+		checksum   VARCHAR(64)  NOT NULL,
+		applied_at TIMESTAMPTZ  NOT NULL DEFAULT now()
+	)`)
+	return err
+}
+
+func loadAppliedMigrations(ctx context.Context, q dbtx) (map[int]appliedMigration, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.Version, &a.Checksum, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[a.Version] = a
+	}
+	return applied, rows.Err()
+}
+
+// withLock runs fn on a dedicated connection holding the migration advisory
+// lock. It blocks until any other replica that is migrating has finished.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("Unable to acquire migration lock: [%s]", err.Error())
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	if err := ensureMigrationTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func (m *Migrator) verify(applied map[int]appliedMigration) error {
+	for _, migration := range m.Migrations {
+		a, ok := applied[migration.Version]
+		if ok && a.Checksum != migration.Checksum {
+			return fmt.Errorf("Migration %d_%s was modified after it was applied (checksum %s, expected %s)",
+				migration.Version, migration.Name, migration.Checksum, a.Checksum)
+		}
+	}
+	return nil
+}
+
+// Up applies every pending migration in version order, each in its own
+// transaction, and returns the migrations it applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := loadAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.verify(applied); err != nil {
+			return err
+		}
+		for _, migration := range m.Migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, migration, migration.Up,
+				"INSERT INTO schema_migrations(version, name, checksum) VALUES($1, $2, $3)",
+				migration.Version, migration.Name, migration.Checksum); err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the last steps applied migrations, newest first.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := loadAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.verify(applied); err != nil {
+			return err
+		}
+		for i := len(m.Migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := m.Migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("Migration %d_%s has no down script", migration.Version, migration.Name)
+			}
+			if err := runMigration(ctx, conn, migration, migration.Down,
+				"DELETE FROM schema_migrations WHERE version=$1", migration.Version); err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+func runMigration(ctx context.Context, conn *sql.Conn, migration Migration, script, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("Unable to run migration %d_%s: [%s]", migration.Version, migration.Name, err.Error())
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Status reports every known migration and whether it has been applied. It
+// only reads schema_migrations, without the migration lock, so that it
+// neither waits for a running migration nor slows it down; while one runs it
+// may report that migration as pending.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var exists bool
+	if err := m.DB.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, err
+	}
+	applied := map[int]appliedMigration{}
+	if exists {
+		var err error
+		if applied, err = loadAppliedMigrations(ctx, m.DB); err != nil {
+			return nil, err
+		}
+	}
+	var statuses []MigrationStatus
+	for _, migration := range m.Migrations {
+		s := MigrationStatus{
+			Version:  migration.Version,
+			Name:     migration.Name,
+			Checksum: migration.Checksum,
+		}
+		if a, ok := applied[migration.Version]; ok {
+			appliedAt := a.AppliedAt
+			s.Applied = true
+			s.AppliedAt = &appliedAt
+			s.Modified = a.Checksum != migration.Checksum
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// runMigrateCommand implements `migrate up`, `migrate down [steps]` and
+// `migrate status`.
+func runMigrateCommand(db *sql.DB, args []string) error {
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [steps]|status")
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := migrator.Up(ctx)
+		for _, migration := range done {
+			fmt.Printf("Applied migration %d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("No pending migrations")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				return fmt.Errorf("Invalid number of steps: %s", args[1])
+			}
+		}
+		done, err := migrator.Down(ctx, steps)
+		for _, migration := range done {
+			fmt.Printf("Reverted migration %d_%s\n", migration.Version, migration.Name)
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			if s.Modified {
+				state += " (modified)"
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unknown migrate command %q, expected up, down or status", args[0])
+	}
+}
@@ -0,0 +1,107 @@
+// migrate_test.go
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	file := func(content string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(content)} }
+	tests := []struct {
+		name         string
+		files        fstest.MapFS
+		wantVersions []int
+		wantErr      string
+	}{
+		{
+			name: "ordered by version",
+			files: fstest.MapFS{
+				"m/0010_later.up.sql":    file("SELECT 10"),
+				"m/0002_second.up.sql":   file("SELECT 2"),
+				"m/0002_second.down.sql": file("SELECT -2"),
+				"m/0001_first.up.sql":    file("SELECT 1"),
+				"m/README.md":            file("not a migration"),
+			},
+			wantVersions: []int{1, 2, 10},
+		},
+		{
+			name:    "no direction",
+			files:   fstest.MapFS{"m/0001_first.sql": file("SELECT 1")},
+			wantErr: "must end in .up.sql or .down.sql",
+		},
+		{
+			name:    "no name",
+			files:   fstest.MapFS{"m/0001.up.sql": file("SELECT 1")},
+			wantErr: "must be named <version>_<name>",
+		},
+		{
+			name:    "invalid version",
+			files:   fstest.MapFS{"m/first_x.up.sql": file("SELECT 1")},
+			wantErr: "invalid version",
+		},
+		{
+			name: "version used twice",
+			files: fstest.MapFS{
+				"m/0001_first.up.sql": file("SELECT 1"),
+				"m/0001_other.up.sql": file("SELECT 1"),
+			},
+			wantErr: "is used by both",
+		},
+		{
+			name:    "down without up",
+			files:   fstest.MapFS{"m/0001_first.down.sql": file("SELECT 1")},
+			wantErr: "has no up script",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tt.files, "m")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadMigrations() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadMigrations() error = %v", err)
+			}
+			var versions []int
+			for _, m := range migrations {
+				versions = append(versions, m.Version)
+				if m.Checksum == "" {
+					t.Errorf("migration %d has no checksum", m.Version)
+				}
+			}
+			if len(versions) != len(tt.wantVersions) {
+				t.Fatalf("versions = %v, want %v", versions, tt.wantVersions)
+			}
+			for i := range versions {
+				if versions[i] != tt.wantVersions[i] {
+					t.Fatalf("versions = %v, want %v", versions, tt.wantVersions)
+				}
+			}
+			if migrations[1].Down != "SELECT -2" {
+				t.Errorf("down script of 0002 = %q", migrations[1].Down)
+			}
+		})
+	}
+}
+
+// TestEmbeddedMigrations checks the migrations shipped in the binary.
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d_%s: versions must be consecutive from 1", m.Version, m.Name)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %d_%s has no down script", m.Version, m.Name)
+		}
+	}
+}
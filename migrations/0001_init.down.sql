@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS error_store;
+DROP TABLE IF EXISTS step_log;
+DROP TABLE IF EXISTS issues;
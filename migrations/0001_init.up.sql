@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS issues (
+    id            SERIAL PRIMARY KEY,
+    tenant_id     VARCHAR(64)  NOT NULL DEFAULT '',
+    vpc_id        VARCHAR(64)  NOT NULL DEFAULT '',
+    region_id     VARCHAR(64)  NOT NULL DEFAULT '',
+    issue_jira_id VARCHAR(64)  NOT NULL DEFAULT '',
+    name          VARCHAR(255) NOT NULL DEFAULT '',
+    data_log      TEXT         NOT NULL DEFAULT '',
+    error_code    VARCHAR(128) NOT NULL DEFAULT '',
+    status        VARCHAR(64)  NOT NULL DEFAULT '',
+    service       VARCHAR(64)  NOT NULL DEFAULT '',
+    created_at    TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    updated_at    TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS issues_issue_jira_id_idx ON issues (issue_jira_id);
+
+CREATE TABLE IF NOT EXISTS step_log (
+    id             SERIAL PRIMARY KEY,
+    issue_id       VARCHAR(64)  NOT NULL,
+    reporter_name  VARCHAR(255) NOT NULL DEFAULT '',
+    supporter_name VARCHAR(255) NOT NULL DEFAULT '',
+    description    TEXT         NOT NULL DEFAULT '',
+    supporter_jira VARCHAR(255) NOT NULL DEFAULT '',
+    status         VARCHAR(64)  NOT NULL DEFAULT '',
+    created_at     TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    updated_at     TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS step_log_issue_id_idx ON step_log (issue_id);
+
+CREATE TABLE IF NOT EXISTS error_store (
+    id          SERIAL PRIMARY KEY,
+    error_code  VARCHAR(128) NOT NULL,
+    name        VARCHAR(255) NOT NULL DEFAULT '',
+    description TEXT         NOT NULL DEFAULT '',
+    service     VARCHAR(64)  NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    updated_at  TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS issues_issue_jira_key_idx;
+
+ALTER TABLE issues DROP COLUMN IF EXISTS issue_jira_key;
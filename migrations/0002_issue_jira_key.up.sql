@@ -0,0 +1,3 @@
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS issue_jira_key VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS issues_issue_jira_key_idx ON issues (issue_jira_key);
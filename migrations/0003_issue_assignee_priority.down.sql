@@ -0,0 +1,2 @@
+ALTER TABLE issues DROP COLUMN IF EXISTS priority;
+ALTER TABLE issues DROP COLUMN IF EXISTS assignee;
@@ -0,0 +1,2 @@
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS assignee VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS priority VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS issues_error_code_idx;
+DROP INDEX IF EXISTS issues_tenant_id_idx;
+DROP INDEX IF EXISTS issues_updated_at_id_idx;
+DROP INDEX IF EXISTS issues_created_at_id_idx;
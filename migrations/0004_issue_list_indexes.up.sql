@@ -0,0 +1,4 @@
+CREATE INDEX IF NOT EXISTS issues_created_at_id_idx ON issues (created_at, id);
+CREATE INDEX IF NOT EXISTS issues_updated_at_id_idx ON issues (updated_at, id);
+CREATE INDEX IF NOT EXISTS issues_tenant_id_idx ON issues (tenant_id);
+CREATE INDEX IF NOT EXISTS issues_error_code_idx ON issues (error_code);
@@ -0,0 +1 @@
+ALTER TABLE error_store DROP CONSTRAINT IF EXISTS error_store_error_code_key;
@@ -0,0 +1,16 @@
+-- Refuse to pick a winner among duplicate definitions of an error code;
+-- operators resolve them and run the migration again.
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(error_code, ', ' ORDER BY error_code) INTO duplicates
+        FROM (SELECT error_code FROM error_store GROUP BY error_code HAVING count(*) > 1) AS d;
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'error_store has duplicate rows for error codes: %', duplicates
+            USING HINT = 'Delete or rename all but one row of each code, then migrate again.';
+    END IF;
+END
+$$;
+
+ALTER TABLE error_store ADD CONSTRAINT error_store_error_code_key UNIQUE (error_code);
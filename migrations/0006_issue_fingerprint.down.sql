@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS issues_fingerprint_last_seen_idx;
+ALTER TABLE issues DROP COLUMN IF EXISTS last_seen_at;
+ALTER TABLE issues DROP COLUMN IF EXISTS occurrence_count;
+ALTER TABLE issues DROP COLUMN IF EXISTS fingerprint;
@@ -0,0 +1,7 @@
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS fingerprint VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS occurrence_count INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+UPDATE issues SET last_seen_at = created_at;
+
+CREATE INDEX IF NOT EXISTS issues_fingerprint_last_seen_idx ON issues (fingerprint, last_seen_at);
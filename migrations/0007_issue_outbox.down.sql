@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS issue_outbox;
+ALTER TABLE issues DROP COLUMN IF EXISTS sync_state;
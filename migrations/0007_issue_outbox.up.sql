@@ -0,0 +1,18 @@
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS sync_state VARCHAR(32) NOT NULL DEFAULT 'synced';
+
+CREATE TABLE IF NOT EXISTS issue_outbox (
+    id              SERIAL PRIMARY KEY,
+    issue_id        INTEGER      NOT NULL REFERENCES issues (id) ON DELETE CASCADE,
+    idempotency_key VARCHAR(64)  NOT NULL UNIQUE,
+    reporter_name   VARCHAR(255) NOT NULL DEFAULT '',
+    attempts        INTEGER      NOT NULL DEFAULT 0,
+    last_error      TEXT         NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    delivered_at    TIMESTAMPTZ,
+    failed_at       TIMESTAMPTZ,
+    created_at      TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    updated_at      TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS issue_outbox_due_idx ON issue_outbox (next_attempt_at)
+    WHERE delivered_at IS NULL AND failed_at IS NULL;
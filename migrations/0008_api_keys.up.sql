@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id            SERIAL PRIMARY KEY,
+    name          VARCHAR(255) NOT NULL,
+    key_prefix    VARCHAR(16)  NOT NULL,
+    key_hash      VARCHAR(64)  NOT NULL UNIQUE,
+    reporter_name VARCHAR(255) NOT NULL,
+    tenant_id     VARCHAR(255) NOT NULL DEFAULT '',
+    created_at    TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    last_used_at  TIMESTAMPTZ,
+    revoked_at    TIMESTAMPTZ
+);
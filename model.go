@@ -2,11 +2,10 @@
 package main
 
 import (
-	"database/sql"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -18,15 +17,67 @@ type BaseModel struct {
 
 type Issues struct {
 	BaseModel
-	TenantID    string `json:"tenantId"`
-	VpcID       string `json:"vpcId"`
-	RegionID    string `json:"regionId"`
-	IssueJiraID string `json:"issueJiraID"`
-	Name        string `json:"name"`
-	DataLog     string `json:"dataLog"`
-	ErrorCode   string `json:"errorCode"`
-	Status      string `json:"status"`
-	Service     string `json:"service"`
+	TenantID     string `json:"tenantId"`
+	VpcID        string `json:"vpcId"`
+	RegionID     string `json:"regionId"`
+	IssueJiraID  string `json:"issueJiraID"`
+	IssueJiraKey string `json:"issueJiraKey"`
+	Name         string `json:"name"`
+	DataLog      string `json:"dataLog"`
+	ErrorCode    string `json:"errorCode"`
+	Status       string `json:"status"`
+	Service      string `json:"service"`
+	Assignee     string `json:"assignee"`
+	Priority     string `json:"priority"`
+
+	Fingerprint     string    `json:"fingerprint"`
+	OccurrenceCount int       `json:"occurrenceCount"`
+	LastSeenAt      time.Time `json:"lastSeenAt"`
+	SyncState       string    `json:"syncState"`
+}
+
+// Sync states of an issue. Issues are stored as pending until the outbox
+// dispatcher has filed them in Jira; failed issues were rejected by Jira.
+const (
+	syncStatePending = "pending_sync"
+	syncStateSynced  = "synced"
+	syncStateFailed  = "sync_failed"
+)
+
+// stepLogIssueID is the step_log issue_id of issue. Issues that are not in
+// Jira yet use a placeholder that is replaced by the Jira id on delivery.
+func (issue Issues) stepLogIssueID() string {
+	if issue.IssueJiraID != "" {
+		return issue.IssueJiraID
+	}
+	return pendingStepLogIssueID(issue.ID)
+}
+
+func pendingStepLogIssueID(id int) string {
+	return fmt.Sprintf("pending-%d", id)
+}
+
+// request rebuilds the report an issue was created from.
+func (issue Issues) request(reporterName string) IssueRequest {
+	return IssueRequest{
+		ErrorCode:    issue.ErrorCode,
+		Content:      issue.DataLog,
+		ReporterName: reporterName,
+		TenantID:     issue.TenantID,
+		VpcID:        issue.VpcID,
+		RegionID:     issue.RegionID,
+		Service:      issue.Service,
+		Name:         issue.Name,
+	}
+}
+
+func (issue Issues) routeInput() RouteInput {
+	return RouteInput{
+		ErrorCode: issue.ErrorCode,
+		Service:   issue.Service,
+		Region:    issue.RegionID,
+		Tenant:    issue.TenantID,
+	}
 }
 
 type IssuesReturn struct {
@@ -58,6 +109,28 @@ type ErrorStore struct {
 	Service     string `json:"service"`
 }
 
+func (e *ErrorStore) Validate() error {
+	var problems []string
+	switch {
+	case e.ErrorCode == "":
+		problems = append(problems, "errorCode is required")
+	case len(e.ErrorCode) > 128:
+		problems = append(problems, "errorCode must be at most 128 characters")
+	case !identifierPattern.MatchString(e.ErrorCode):
+		problems = append(problems, "errorCode contains invalid characters")
+	}
+	if len(e.Name) > 255 {
+		problems = append(problems, "name must be at most 255 characters")
+	}
+	if e.Service != "" && (len(e.Service) > 64 || !identifierPattern.MatchString(e.Service)) {
+		problems = append(problems, "service must be at most 64 letters, digits, '_', '.' or '-'")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid error definition: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
 type IssueResponse struct {
 	Expand string `json:"expand"`
 	ID     string `json:"id"`
@@ -85,183 +158,228 @@ type IssueRequest struct {
 	ErrorCode    string `json:"errorCode"`
 	Content      string `json:"content"`
 	ReporterName string `json:"reporterName"`
+	TenantID     string `json:"tenantId"`
+	VpcID        string `json:"vpcId"`
+	RegionID     string `json:"regionId"`
+	Service      string `json:"service"`
+	Name         string `json:"name"`
 }
 
-type ResponseJira struct {
-	Id string `json:"id"`
+// attributeTo takes the reporter and tenant of the request from the principal
+// that made it. Only operators may report for a tenant other than their own.
+func (i *IssueRequest) attributeTo(p *Principal) error {
+	if p == nil {
+		return nil
+	}
+	i.ReporterName = p.Name
+	switch {
+	case p.hasRole(roleOperator):
+		if i.TenantID == "" {
+			i.TenantID = p.TenantID
+		}
+	case p.TenantID == "":
+		return fmt.Errorf("Not allowed to report issues: %s belongs to no tenant", p.Name)
+	case i.TenantID != "" && i.TenantID != p.TenantID:
+		return fmt.Errorf("Not allowed to report issues for tenant %s", i.TenantID)
+	default:
+		i.TenantID = p.TenantID
+	}
+	return nil
 }
 
-type LogIssueResponse struct {
-	Id            string `json:"id"`
-	IssueId       string `json:"issueId"`
-	Status        string `json:"status"`
-	ReporterName  string `json:"reporterName"`
-	SupporterName string `json:"supporterName"`
-	Description   string `json:"description"`
-}
+var (
+	identifierPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	regionPattern     = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 _.-]*$`)
+)
 
-func (issue *Issues) ApiJob(db *sql.DB, issueJiraID string) {
-	for {
-		status1 := issue.GetIssueStatusFromDB(db, issueJiraID)
-		status2 := issue.GetJiraIssueStatus(db, issueJiraID)
-		if status1 != status2 {
-			if err := issue.UpdateIssueStatusInDB(db, status2); err != nil {
-				fmt.Printf("Unable to update status of issue in DB")
+// Validate checks the fields an issue is attributed by before anything is
+// written, and reports every problem at once.
+func (i *IssueRequest) Validate() error {
+	var problems []string
+	check := func(field, value string, required bool, maxLen int, pattern *regexp.Regexp) {
+		switch {
+		case value == "":
+			if required {
+				problems = append(problems, field+" is required")
 			}
+		case len(value) > maxLen:
+			problems = append(problems, fmt.Sprintf("%s must be at most %d characters", field, maxLen))
+		case pattern != nil && !pattern.MatchString(value):
+			problems = append(problems, field+" contains invalid characters")
 		}
-		time.Sleep(30 * time.Second)
 	}
+	check("errorCode", i.ErrorCode, true, 128, identifierPattern)
+	check("content", strings.TrimSpace(i.Content), true, 1<<20, nil)
+	check("reporterName", i.ReporterName, false, 255, nil)
+	check("tenantId", i.TenantID, true, 64, identifierPattern)
+	check("vpcId", i.VpcID, true, 64, identifierPattern)
+	check("regionId", i.RegionID, true, 64, regionPattern)
+	check("service", i.Service, false, 64, identifierPattern)
+	check("name", i.Name, false, 255, nil)
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid issue: %s", strings.Join(problems, "; "))
+	}
+	return nil
 }
 
-func (issue *Issues) GetIssueStatusFromDB(db *sql.DB, issueJiraID string) string {
-	var status string
-	// db.QueryRow("SELECT status FROM issues WHERE issue_jira_id = ?", issueJiraID)
-	err := db.QueryRow("SELECT status FROM issues WHERE issue_jira_id=$1", issueJiraID).Scan(status)
-	if err != nil {
-		fmt.Printf("Unable to query status with issue_jira_id from issues table")
+// withCatalog fills the name and service the client left out from the
+// error_store entry for the error code. entry may be nil.
+func (i IssueRequest) withCatalog(entry *ErrorStore) IssueRequest {
+	if entry == nil {
+		return i
+	}
+	if i.Name == "" {
+		i.Name = entry.Name
+	}
+	if i.Service == "" {
+		i.Service = entry.Service
 	}
-	return status
+	return i
 }
 
-func (issue *Issues) GetJiraIssueStatus(db *sql.DB, issueJiraID string) string {
-	url := fmt.Sprintf("http://10.0.0.4:8080/rest/api/2/issue/%s?fields=status", issueJiraID)
-	method := "GET"
-	var issueResponse IssueResponse
-
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, nil)
+// IssueUpdateRequest is the body of PATCH /issue/{issue_jira_id}; fields left
+// out of the JSON are not changed.
+type IssueUpdateRequest struct {
+	Status   *string `json:"status"`
+	Name     *string `json:"name"`
+	Service  *string `json:"service"`
+	Assignee *string `json:"assignee"`
+	Priority *string `json:"priority"`
+}
 
-	if err != nil {
-		fmt.Printf("Unable to create new request to get issue of Jira: [%s]", err.Error())
-		return ""
+func (u *IssueUpdateRequest) Validate() error {
+	var problems []string
+	check := func(field string, value *string, maxLen int, pattern *regexp.Regexp) {
+		switch {
+		case value == nil:
+		case *value == "" && pattern != nil:
+			problems = append(problems, field+" must not be empty")
+		case len(*value) > maxLen:
+			problems = append(problems, fmt.Sprintf("%s must be at most %d characters", field, maxLen))
+		case pattern != nil && !pattern.MatchString(*value):
+			problems = append(problems, field+" contains invalid characters")
+		}
 	}
-	req.Header.Add("Content-Type", "application/json")
-
-	res, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("Unable to perform request getting Issue Jira: [%s]", err.Error())
-		return ""
+	if u.Status == nil && u.Name == nil && u.Service == nil && u.Assignee == nil && u.Priority == nil {
+		problems = append(problems, "at least one of status, name, service, assignee or priority is required")
 	}
-	defer res.Body.Close()
-
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		fmt.Printf("Unable to read body of response from Jira: [%s]\n", err.Error())
-		return ""
+	if u.Status != nil {
+		if _, ok := issueWorkflow[normalizeStatus(*u.Status)]; !ok {
+			problems = append(problems, "status must be one of "+strings.Join(workflowStatuses(), ", "))
+		}
 	}
-	dataBody := []byte(body)
-	err = json.Unmarshal(dataBody, &issueResponse)
-	if err != nil {
-		fmt.Printf("Unable to Unmarshal response body from jira: [%s]\n", err.Error())
+	check("name", u.Name, 255, nil)
+	check("service", u.Service, 64, identifierPattern)
+	check("assignee", u.Assignee, 255, nil)
+	check("priority", u.Priority, 64, nil)
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid issue update: %s", strings.Join(problems, "; "))
 	}
+	return nil
+}
 
-	return issueResponse.Fields.Status.Name
+// StepLogRequest is the body of POST /issue/{issue_jira_id}/log, a note a
+// supporter adds to the history of an issue.
+type StepLogRequest struct {
+	Description   string `json:"description"`
+	SupporterJira string `json:"supporterJira"`
 }
 
-func (errorStore *ErrorStore) createError(db *sql.DB) error {
-	err := db.QueryRow("INSERT INTO error_store(error_code, name, description, service, created_at, updated_at) VALUES($1, $2, $3, $4, $5, $6) RETURNING id",
-		errorStore.ErrorCode, errorStore.Name, errorStore.Description, errorStore.Service, errorStore.CreatedAt, errorStore.UpdatedAt).Scan(&errorStore.ID)
-	if err != nil {
-		return err
+func (l *StepLogRequest) Validate() error {
+	var problems []string
+	if strings.TrimSpace(l.Description) == "" {
+		problems = append(problems, "description must not be empty")
+	} else if len(l.Description) > 1<<16 {
+		problems = append(problems, fmt.Sprintf("description must be at most %d characters", 1<<16))
+	}
+	if len(l.SupporterJira) > 255 {
+		problems = append(problems, "supporterJira must be at most 255 characters")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid step log: %s", strings.Join(problems, "; "))
 	}
 	return nil
 }
 
-func (issue *Issues) createIssue(db *sql.DB) error {
-	err := db.QueryRow("INSERT INTO issues(tenant_id, vpc_id, region_id, issue_jira_id, name, data_log, error_code, status, service, created_at, updated_at) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id",
-		issue.TenantID, issue.VpcID, issue.RegionID, issue.IssueJiraID, issue.Name, issue.DataLog, issue.ErrorCode, issue.Status, issue.Service, issue.CreatedAt, issue.UpdatedAt).Scan(&issue.ID)
-	if err != nil {
-		return err
-	}
-	return nil
+// issueWorkflow lists the statuses an issue may move to from each status.
+// Issues in a status that is not listed here, e.g. a custom Jira status
+// picked up by the sync worker, may move to any listed status.
+var issueWorkflow = map[string][]string{
+	"TO DO":       {"IN PROGRESS", "DONE"},
+	"IN PROGRESS": {"TO DO", "DONE"},
+	"DONE":        {"TO DO"},
+	"DELETED":     {},
 }
 
-func (issue *Issues) getStatusIssue(db *sql.DB, issueJiraID string) error {
-	// var status string
-	err := db.QueryRow("SELECT status FROM issues WHERE issue_jira_id=$1", issueJiraID).Scan(&issue.Status)
-	if err != nil {
-		fmt.Printf("Unable to query status with issue_jira_id from issues table")
-		return fmt.Errorf("Unable to query status with issue_jira_id from issues table: [%s]", err.Error())
-	}
-	return err
+func normalizeStatus(status string) string {
+	return strings.ToUpper(strings.TrimSpace(status))
 }
 
-func (issue *Issues) UpdateIssueStatusInDB(db *sql.DB, status string) error {
-	_, err := db.Exec("UPDATE issues SET status=$1", status)
-	return err
+func workflowStatuses() []string {
+	statuses := make([]string, 0, len(issueWorkflow))
+	for status := range issueWorkflow {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	return statuses
 }
 
-func (app *App) UpdateIssueJiraIdInDB(db *sql.DB, issueJiraId string) error {
-	_, err := db.Exec("UPDATE issues SET issue_jira_id=$1", issueJiraId)
-	return err
+// TransitionError is returned when the workflow does not allow an issue to
+// move from one status to another.
+type TransitionError struct {
+	From string
+	To   string
 }
 
-func (issue *Issues) DeleteIssue(db *sql.DB, issueJiraID string) error {
-	_, err := db.Exec("DELETE FROM issues WHERE issue_jira_id=$1", issueJiraID)
-	return err
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("Issue cannot move from %s to %s", e.From, e.To)
 }
 
-// func (app *App) GetIssue(db *sql.DB) (sql.Result, error) {
-func (app *App) GetIssue(db *sql.DB) ([]Issues, error) {
-	rows, err := db.Query("SELECT id, tenant_id, vpc_id, region_id, issue_jira_id, name, data_log, error_code, status, service, created_at, updated_at FROM issues")
-	if err != nil {
-		return nil, err
+func checkTransition(from, to string) error {
+	from, to = normalizeStatus(from), normalizeStatus(to)
+	if from == to {
+		return nil
 	}
-	defer rows.Close()
-	issues := []Issues{}
-	for rows.Next() {
-		var i Issues
-		if err := rows.Scan(&i.ID, &i.TenantID, &i.VpcID, &i.RegionID, &i.IssueJiraID, &i.Name, &i.DataLog, &i.ErrorCode,
-			&i.Status, &i.Service, &i.CreatedAt, &i.UpdatedAt); err != nil {
-			return nil, err
+	allowed, ok := issueWorkflow[from]
+	if !ok {
+		return nil
+	}
+	for _, status := range allowed {
+		if status == to {
+			return nil
 		}
-		issues = append(issues, i)
 	}
-	// issue, err := db.Exec("SELECT * FROM issues")
-	// return issue, err
-	return issues, nil
+	return &TransitionError{From: from, To: to}
 }
 
-func (issue *Issues) GetIssueByJiraID(db *sql.DB, issueJiraID string) error {
-	return db.QueryRow("SELECT id, tenant_id, vpc_id, region_id, name, data_log, error_code, status, service, created_at, updated_at FROM issues WHERE issue_jira_id=$1",
-		issueJiraID).Scan(&issue.ID, &issue.TenantID, &issue.VpcID, &issue.RegionID, &issue.Name, &issue.DataLog, &issue.ErrorCode,
-		&issue.Status, &issue.Service, &issue.CreatedAt, &issue.UpdatedAt)
+// PushIssueRequest asks for a locally stored issue to be filed in Jira.
+type PushIssueRequest struct {
+	ID           int    `json:"id"`
+	ReporterName string `json:"reporterName"`
 }
 
-func AddStepLog(db *sql.DB, IssueID, reporterName, supporterName, description, status string, createdAt, updatedAt time.Time) error {
-	var stepLog StepLog
-	err := db.QueryRow("INSERT INTO step_log(issue_id, reporter_name, supporter_name, description, status, created_at, updated_at) VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING id",
-		IssueID, reporterName, supporterName, description, status, createdAt, updatedAt).Scan(&stepLog.ID)
-	if err != nil {
-		return err
-	}
-	return nil
+type ResponseJira struct {
+	Id            string            `json:"id"`
+	Key           string            `json:"key"`
+	Self          string            `json:"self"`
+	ErrorMessages []string          `json:"errorMessages"`
+	Errors        map[string]string `json:"errors"`
 }
 
-func (app *App) GetLogsByIssueJiraId(db *sql.DB, issueJiraID string) ([]LogIssueResponse, error) {
-	textQ := "SELECT id, issue_id, reporter_name, supporter_name, description, status FROM step_log WHERE issue_id='" + string(issueJiraID) + "'"
-	fmt.Print(textQ)
-	rows, err := db.Query(textQ)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	logs := []LogIssueResponse{}
-
-	count := 0
-
-	for rows.Next() {
-		count++
-		var issue LogIssueResponse
-		if err := rows.Scan(&issue.Id, &issue.IssueId, &issue.ReporterName, &issue.SupporterName, &issue.Description, &issue.Status); err != nil {
-			return nil, err
-		}
-		logs = append(logs, issue)
-	}
-
-	fmt.Print("count", count)
+type LogIssueResponse struct {
+	Id            string `json:"id"`
+	IssueId       string `json:"issueId"`
+	Status        string `json:"status"`
+	ReporterName  string `json:"reporterName"`
+	SupporterName string `json:"supporterName"`
+	Description   string `json:"description"`
+}
 
-	// issue, err := db.Exec("SELECT * FROM issues")
-	// return issue, err
-	return logs, nil
+// StatusChange is a new status for an issue together with the step_log entry
+// that records it.
+type StatusChange struct {
+	Status        string
+	ReporterName  string
+	SupporterName string
+	Description   string
 }
@@ -0,0 +1,162 @@
+// outbox.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// OutboxDispatcher files the issues queued in the outbox in the tracker. A
+// failed delivery is retried with a doubling backoff; an issue the tracker
+// rejects as invalid is marked failed instead. Each replica claims the
+// entries it delivers for ClaimLease, which must outlast delivering a batch.
+type OutboxDispatcher struct {
+	Outbox  OutboxStore
+	Issues  IssueStore
+	Tracker Tracker
+	Logger  *slog.Logger
+	// Build turns a stored issue into the tracker issue to file.
+	Build func(ctx context.Context, issue Issues, reporterName string) (TrackerIssue, error)
+
+	Interval    time.Duration
+	BatchSize   int
+	CallTimeout time.Duration
+	MaxBackoff  time.Duration
+	ClaimLease  time.Duration
+
+	wake chan struct{}
+}
+
+func NewOutboxDispatcher(outbox OutboxStore, issues IssueStore, tracker Tracker) *OutboxDispatcher {
+	return &OutboxDispatcher{
+		Outbox:      outbox,
+		Issues:      issues,
+		Tracker:     tracker,
+		Logger:      slog.Default(),
+		Interval:    5 * time.Second,
+		BatchSize:   50,
+		CallTimeout: 10 * time.Second,
+		MaxBackoff:  30 * time.Minute,
+		ClaimLease:  10 * time.Minute,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Notify asks the dispatcher to look for due entries now instead of at the
+// next tick.
+func (d *OutboxDispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due entries every Interval, or when notified, until ctx is
+// cancelled.
+func (d *OutboxDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		if err := d.DispatchDue(ctx); err != nil && ctx.Err() == nil {
+			d.Logger.ErrorContext(ctx, "unable to dispatch outbox", "error", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// DispatchDue delivers every entry that is due, BatchSize at a time.
+func (d *OutboxDispatcher) DispatchDue(ctx context.Context) error {
+	for {
+		now := time.Now()
+		batch, err := d.Outbox.Claim(ctx, now, now.Add(d.ClaimLease), d.BatchSize)
+		if err != nil {
+			return err
+		}
+		for i := range batch {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err := d.deliver(ctx, &batch[i]); err != nil {
+				return err
+			}
+		}
+		if len(batch) < d.BatchSize {
+			return nil
+		}
+	}
+}
+
+// deliver makes one delivery attempt. Tracker errors are recorded on the
+// entry; only errors storing the outcome are returned.
+func (d *OutboxDispatcher) deliver(ctx context.Context, entry *OutboxEntry) error {
+	issue, err := d.Issues.GetByID(ctx, entry.IssueID)
+	if err != nil {
+		if errors.Is(err, ErrIssueNotFound) {
+			return d.Outbox.Fail(ctx, entry, err)
+		}
+		return err
+	}
+
+	created, err := d.create(ctx, issue, entry)
+	if err != nil {
+		var jiraErr *JiraError
+		if errors.As(err, &jiraErr) && jiraErr.StatusCode == http.StatusBadRequest {
+			d.Logger.ErrorContext(ctx, "Jira rejected issue", "issue_id", issue.ID, "error", err.Error())
+			return d.Outbox.Fail(ctx, entry, err)
+		}
+		d.Logger.WarnContext(ctx, "unable to create issue in Jira", "issue_id", issue.ID, "attempt", entry.Attempts+1, "error", err.Error())
+		return d.Outbox.Retry(ctx, entry, err, time.Now().Add(d.backoff(entry.Attempts+1)))
+	}
+
+	if err := d.Outbox.Delivered(ctx, entry, &issue, created, StepLog{
+		ReporterName:  "xplat",
+		SupporterName: "xplat",
+		Description:   fmt.Sprintf("Filed in Jira as %s", created.Key),
+		Status:        issue.Status,
+	}); err != nil {
+		return err
+	}
+	d.Logger.InfoContext(ctx, "filed issue in Jira", "issue_id", issue.ID, "issue_jira_key", created.Key)
+	return nil
+}
+
+func (d *OutboxDispatcher) create(ctx context.Context, issue Issues, entry *OutboxEntry) (*CreatedIssue, error) {
+	if issue.IssueJiraID != "" {
+		return &CreatedIssue{ID: issue.IssueJiraID, Key: issue.IssueJiraKey}, nil
+	}
+	trackerIssue, err := d.Build(ctx, issue, entry.ReporterName)
+	if err != nil {
+		return nil, err
+	}
+	trackerIssue.IdempotencyKey = entry.IdempotencyKey
+
+	callCtx, cancel := context.WithTimeout(ctx, d.CallTimeout)
+	defer cancel()
+	created, err := d.Tracker.CreateIssue(callCtx, trackerIssue)
+	if err == nil {
+		appMetrics.issueCreated(trackerIssue.RoutingRule, trackerIssue.ProjectID)
+	}
+	return created, err
+}
+
+// backoff doubles the wait after each failed attempt, up to MaxBackoff.
+func (d *OutboxDispatcher) backoff(attempts int) time.Duration {
+	backoff := d.Interval
+	for i := 1; i < attempts && backoff < d.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > d.MaxBackoff {
+		backoff = d.MaxBackoff
+	}
+	return backoff
+}
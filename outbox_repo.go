@@ -0,0 +1,228 @@
+// outbox_repo.go
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// fingerprintLockSpace is the first key of the transaction-scoped advisory
+// locks EnqueueOrRecord takes on fingerprints. Two-key locks never collide
+// with single-key ones such as migrationLockID.
+const fingerprintLockSpace = 726164110
+
+// OutboxEntry asks for an issue to be filed in Jira. The idempotency key
+// makes retried deliveries find the Jira issue an earlier attempt created.
+type OutboxEntry struct {
+	ID             int        `json:"id"`
+	IssueID        int        `json:"issueId"`
+	IdempotencyKey string     `json:"idempotencyKey"`
+	ReporterName   string     `json:"reporterName"`
+	Attempts       int        `json:"attempts"`
+	LastError      string     `json:"lastError"`
+	NextAttemptAt  time.Time  `json:"nextAttemptAt"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+	FailedAt       *time.Time `json:"failedAt,omitempty"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func newIdempotencyKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+const outboxColumns = "id, issue_id, idempotency_key, reporter_name, attempts, last_error, next_attempt_at, delivered_at, failed_at, created_at, updated_at"
+
+func scanOutboxEntry(row rowScanner, entry *OutboxEntry) error {
+	return row.Scan(&entry.ID, &entry.IssueID, &entry.IdempotencyKey, &entry.ReporterName, &entry.Attempts, &entry.LastError,
+		&entry.NextAttemptAt, &entry.DeliveredAt, &entry.FailedAt, &entry.CreatedAt, &entry.UpdatedAt)
+}
+
+type OutboxRepo struct {
+	repo
+}
+
+// OutboxStore is the part of OutboxRepo the dispatcher uses, so that it can
+// be tested without Postgres.
+type OutboxStore interface {
+	Claim(ctx context.Context, now, until time.Time, limit int) ([]OutboxEntry, error)
+	Retry(ctx context.Context, entry *OutboxEntry, cause error, nextAttempt time.Time) error
+	Fail(ctx context.Context, entry *OutboxEntry, cause error) error
+	Delivered(ctx context.Context, entry *OutboxEntry, issue *Issues, created *CreatedIssue, stepLog StepLog) error
+}
+
+func NewOutboxRepo(db *sql.DB) *OutboxRepo {
+	return &OutboxRepo{repo{db: db}}
+}
+
+func (r *OutboxRepo) WithTx(tx *sql.Tx) *OutboxRepo {
+	return &OutboxRepo{repo{db: r.db, tx: tx}}
+}
+
+// Enqueue stores issue as pending together with its first step_log entry and
+// an outbox entry that is due immediately, all in one transaction. It fills
+// in the IDs and the idempotency key.
+func (r *OutboxRepo) Enqueue(ctx context.Context, issue *Issues, entry *OutboxEntry, stepLog StepLog) error {
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		issue.SyncState = syncStatePending
+		if err := (&IssueRepo{repo{tx: tx}}).Create(ctx, issue); err != nil {
+			return err
+		}
+		stepLog.IssueID = issue.stepLogIssueID()
+		if err := (&StepLogRepo{repo{tx: tx}}).Add(ctx, &stepLog); err != nil {
+			return err
+		}
+		return insertOutboxEntry(ctx, tx, issue.ID, entry)
+	})
+}
+
+// Requeue queues issue, found by its primary key, to be filed in Jira again:
+// it marks the issue pending as IssueRepo.MarkPending does and adds stepLog
+// and an outbox entry that is due immediately, all in one transaction. The
+// step_log entries stay under the pending placeholder until Delivered moves
+// them to the Jira id.
+func (r *OutboxRepo) Requeue(ctx context.Context, issue *Issues, entry *OutboxEntry, stepLog StepLog) error {
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		if err := (&IssueRepo{repo{tx: tx}}).MarkPending(ctx, issue); err != nil {
+			return err
+		}
+		stepLog.IssueID = issue.stepLogIssueID()
+		stepLog.Status = issue.Status
+		if err := (&StepLogRepo{repo{tx: tx}}).Add(ctx, &stepLog); err != nil {
+			return err
+		}
+		return insertOutboxEntry(ctx, tx, issue.ID, entry)
+	})
+}
+
+// insertOutboxEntry adds entry for the issue with the given ID, due
+// immediately, and fills in its ID and idempotency key.
+func insertOutboxEntry(ctx context.Context, tx *sql.Tx, issueID int, entry *OutboxEntry) error {
+	if entry.IdempotencyKey == "" {
+		key, err := newIdempotencyKey()
+		if err != nil {
+			return err
+		}
+		entry.IdempotencyKey = key
+	}
+	now := time.Now()
+	entry.IssueID = issueID
+	entry.NextAttemptAt, entry.CreatedAt, entry.UpdatedAt = now, now, now
+	return traced(tx).QueryRowContext(ctx, "INSERT INTO issue_outbox(issue_id, idempotency_key, reporter_name, next_attempt_at, created_at, updated_at) VALUES($1, $2, $3, $4, $5, $6) RETURNING id",
+		entry.IssueID, entry.IdempotencyKey, entry.ReporterName, entry.NextAttemptAt, entry.CreatedAt, entry.UpdatedAt).Scan(&entry.ID)
+}
+
+// EnqueueOrRecord counts a report against the open issue with the same
+// fingerprint that was last seen at or after since, as
+// IssueRepo.RecordOccurrence does, and returns that issue with recorded set.
+// When there is none it enqueues issue as Enqueue does. Both run in one
+// transaction holding an advisory lock on the fingerprint, so concurrent
+// first reports of a problem open a single ticket.
+func (r *OutboxRepo) EnqueueOrRecord(ctx context.Context, issue *Issues, entry *OutboxEntry, stepLog StepLog, since time.Time) (existing Issues, recorded bool, err error) {
+	err = r.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := traced(tx).ExecContext(ctx, "SELECT pg_advisory_xact_lock($1, hashtext($2))", fingerprintLockSpace, issue.Fingerprint); err != nil {
+			return err
+		}
+		var err error
+		existing, err = (&IssueRepo{repo{tx: tx}}).RecordOccurrence(ctx, issue.Fingerprint, since, stepLog)
+		if err == nil {
+			recorded = true
+			return nil
+		}
+		if !errors.Is(err, ErrIssueNotFound) {
+			return err
+		}
+		return r.WithTx(tx).Enqueue(ctx, issue, entry, stepLog)
+	})
+	return existing, recorded, err
+}
+
+// Claim returns up to limit undelivered entries whose next attempt is due,
+// oldest first, and pushes their next attempt to until so that other
+// replicas skip them meanwhile. Rows another replica is claiming are
+// skipped rather than waited for. An entry whose claimer dies before
+// recording the outcome is due again once until has passed.
+func (r *OutboxRepo) Claim(ctx context.Context, now, until time.Time, limit int) ([]OutboxEntry, error) {
+	rows, err := r.q().QueryContext(ctx, "WITH claimed AS (UPDATE issue_outbox SET next_attempt_at=$2, updated_at=$1 WHERE id IN (SELECT id FROM issue_outbox WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= $1 ORDER BY next_attempt_at, id LIMIT $3 FOR UPDATE SKIP LOCKED) RETURNING "+outboxColumns+") SELECT "+outboxColumns+" FROM claimed ORDER BY created_at, id",
+		now, until, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []OutboxEntry{}
+	for rows.Next() {
+		var entry OutboxEntry
+		if err := scanOutboxEntry(rows, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Retry records a failed delivery attempt and when to try again.
+func (r *OutboxRepo) Retry(ctx context.Context, entry *OutboxEntry, cause error, nextAttempt time.Time) error {
+	entry.Attempts++
+	entry.LastError = cause.Error()
+	entry.NextAttemptAt = nextAttempt
+	entry.UpdatedAt = time.Now()
+	_, err := r.q().ExecContext(ctx, "UPDATE issue_outbox SET attempts=$1, last_error=$2, next_attempt_at=$3, updated_at=$4 WHERE id=$5",
+		entry.Attempts, entry.LastError, entry.NextAttemptAt, entry.UpdatedAt, entry.ID)
+	return err
+}
+
+// Fail gives up on entry and marks its issue as failed to sync.
+func (r *OutboxRepo) Fail(ctx context.Context, entry *OutboxEntry, cause error) error {
+	now := time.Now()
+	entry.Attempts++
+	entry.LastError = cause.Error()
+	entry.FailedAt = &now
+	entry.UpdatedAt = now
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := traced(tx).ExecContext(ctx, "UPDATE issue_outbox SET attempts=$1, last_error=$2, failed_at=$3, updated_at=$3 WHERE id=$4",
+			entry.Attempts, entry.LastError, now, entry.ID); err != nil {
+			return err
+		}
+		_, err := traced(tx).ExecContext(ctx, "UPDATE issues SET sync_state=$1, updated_at=$2 WHERE id=$3", syncStateFailed, now, entry.IssueID)
+		return err
+	})
+}
+
+// Delivered stores the Jira id and key of issue, moves its step_log entries
+// from the pending placeholder to the Jira id, adds stepLog and marks entry
+// delivered, all in one transaction.
+func (r *OutboxRepo) Delivered(ctx context.Context, entry *OutboxEntry, issue *Issues, created *CreatedIssue, stepLog StepLog) error {
+	now := time.Now()
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := (&IssueRepo{repo{tx: tx}}).SetJiraID(ctx, issue, created); err != nil {
+			return err
+		}
+		stepLogs := &StepLogRepo{repo{tx: tx}}
+		if err := stepLogs.Reassign(ctx, pendingStepLogIssueID(issue.ID), issue.IssueJiraID); err != nil {
+			return err
+		}
+		stepLog.IssueID = issue.IssueJiraID
+		if err := stepLogs.Add(ctx, &stepLog); err != nil {
+			return err
+		}
+		_, err := traced(tx).ExecContext(ctx, "UPDATE issue_outbox SET attempts=attempts+1, last_error='', delivered_at=$1, updated_at=$1 WHERE id=$2", now, entry.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	entry.Attempts++
+	entry.LastError = ""
+	entry.DeliveredAt = &now
+	entry.UpdatedAt = now
+	return nil
+}
@@ -0,0 +1,195 @@
+// outbox_test.go
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// memoryOutboxStore records what the dispatcher does with entries.
+type memoryOutboxStore struct {
+	retried   []OutboxEntry
+	failed    []OutboxEntry
+	delivered []OutboxEntry
+	stepLogs  []StepLog
+}
+
+func (s *memoryOutboxStore) Claim(ctx context.Context, now, until time.Time, limit int) ([]OutboxEntry, error) {
+	return nil, nil
+}
+
+func (s *memoryOutboxStore) Retry(ctx context.Context, entry *OutboxEntry, cause error, nextAttempt time.Time) error {
+	entry.Attempts++
+	entry.LastError = cause.Error()
+	entry.NextAttemptAt = nextAttempt
+	s.retried = append(s.retried, *entry)
+	return nil
+}
+
+func (s *memoryOutboxStore) Fail(ctx context.Context, entry *OutboxEntry, cause error) error {
+	entry.Attempts++
+	entry.LastError = cause.Error()
+	s.failed = append(s.failed, *entry)
+	return nil
+}
+
+func (s *memoryOutboxStore) Delivered(ctx context.Context, entry *OutboxEntry, issue *Issues, created *CreatedIssue, stepLog StepLog) error {
+	issue.IssueJiraID = created.ID
+	issue.IssueJiraKey = created.Key
+	issue.SyncState = syncStateSynced
+	stepLog.IssueID = created.ID
+	entry.Attempts++
+	s.delivered = append(s.delivered, *entry)
+	s.stepLogs = append(s.stepLogs, stepLog)
+	return nil
+}
+
+// failingTracker fails every issue creation with err.
+type failingTracker struct {
+	*MemoryTracker
+	err error
+}
+
+func (t failingTracker) CreateIssue(ctx context.Context, issue TrackerIssue) (*CreatedIssue, error) {
+	return nil, t.err
+}
+
+func pendingIssue(id int) Issues {
+	issue := Issues{
+		TenantID:  "t1",
+		Name:      "vm_boot",
+		DataLog:   "VM failed to boot",
+		ErrorCode: "vm_boot",
+		Status:    "TO DO",
+		Service:   "vm",
+		SyncState: syncStatePending,
+	}
+	issue.ID = id
+	return issue
+}
+
+func TestOutboxDispatcherDeliver(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup prepares the issues and the tracker before delivering an
+		// entry for issue 1 with idempotency key key-1.
+		setup func(issues *memoryIssueStore, tracker *MemoryTracker)
+		fail  error
+
+		wantDelivered  string
+		wantFailed     bool
+		wantRetried    bool
+		wantJiraIssues int
+	}{
+		{
+			name: "files a pending issue",
+			setup: func(issues *memoryIssueStore, tracker *MemoryTracker) {
+				issues.add(pendingIssue(1))
+			},
+			wantDelivered:  "MEM-1",
+			wantJiraIssues: 1,
+		},
+		{
+			name: "finds the Jira issue an earlier attempt created",
+			setup: func(issues *memoryIssueStore, tracker *MemoryTracker) {
+				issues.add(pendingIssue(1))
+				tracker.CreateIssue(context.Background(), TrackerIssue{Summary: "earlier attempt", IdempotencyKey: "key-1"})
+			},
+			wantDelivered:  "MEM-1",
+			wantJiraIssues: 1,
+		},
+		{
+			name: "issue already has a Jira id",
+			setup: func(issues *memoryIssueStore, tracker *MemoryTracker) {
+				issue := pendingIssue(1)
+				issue.IssueJiraID, issue.IssueJiraKey = "10042", "VM-42"
+				issues.add(issue)
+			},
+			wantDelivered: "VM-42",
+		},
+		{
+			name:       "issue was deleted",
+			setup:      func(issues *memoryIssueStore, tracker *MemoryTracker) {},
+			wantFailed: true,
+		},
+		{
+			name: "Jira rejects the issue",
+			setup: func(issues *memoryIssueStore, tracker *MemoryTracker) {
+				issues.add(pendingIssue(1))
+			},
+			fail:       &JiraError{StatusCode: http.StatusBadRequest, ErrorMessages: []string{"project is required"}},
+			wantFailed: true,
+		},
+		{
+			name: "Jira is unavailable",
+			setup: func(issues *memoryIssueStore, tracker *MemoryTracker) {
+				issues.add(pendingIssue(1))
+			},
+			fail:        ErrTrackerUnavailable,
+			wantRetried: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues := newMemoryIssueStore()
+			memory := NewMemoryTracker()
+			tt.setup(issues, memory)
+			var tracker Tracker = memory
+			if tt.fail != nil {
+				tracker = failingTracker{MemoryTracker: memory, err: tt.fail}
+			}
+			outbox := &memoryOutboxStore{}
+			d := NewOutboxDispatcher(outbox, issues, tracker)
+			d.Build = func(ctx context.Context, issue Issues, reporterName string) (TrackerIssue, error) {
+				return newTrackerIssue(issue.request(reporterName), Route{Rule: "vm", ProjectID: "10000"}, nil), nil
+			}
+
+			entry := OutboxEntry{ID: 7, IssueID: 1, IdempotencyKey: "key-1", ReporterName: "monitoring"}
+			start := time.Now()
+			if err := d.deliver(context.Background(), &entry); err != nil {
+				t.Fatalf("deliver() error = %v", err)
+			}
+
+			if got := len(outbox.delivered) == 1; got != (tt.wantDelivered != "") {
+				t.Fatalf("delivered = %v", outbox.delivered)
+			}
+			if tt.wantDelivered != "" {
+				if want := "Filed in Jira as " + tt.wantDelivered; outbox.stepLogs[0].Description != want {
+					t.Errorf("step log = %q, want %q", outbox.stepLogs[0].Description, want)
+				}
+			}
+			if got := len(outbox.failed) == 1; got != tt.wantFailed {
+				t.Errorf("failed = %v, want %v", outbox.failed, tt.wantFailed)
+			}
+			if got := len(outbox.retried) == 1; got != tt.wantRetried {
+				t.Fatalf("retried = %v, want %v", outbox.retried, tt.wantRetried)
+			}
+			if tt.wantRetried {
+				if retry := outbox.retried[0].NextAttemptAt; retry.Before(start.Add(d.Interval)) || retry.After(time.Now().Add(d.Interval)) {
+					t.Errorf("next attempt = %s, want %s after now", retry, d.Interval)
+				}
+			}
+			if len(memory.Issues) != tt.wantJiraIssues {
+				t.Errorf("Jira has %d issues, want %d", len(memory.Issues), tt.wantJiraIssues)
+			}
+			for _, created := range memory.Issues {
+				if created.IdempotencyKey != "key-1" {
+					t.Errorf("Jira issue has idempotency key %q, want key-1", created.IdempotencyKey)
+				}
+			}
+		})
+	}
+}
+
+func TestOutboxDispatcherBackoff(t *testing.T) {
+	d := NewOutboxDispatcher(&memoryOutboxStore{}, newMemoryIssueStore(), NewMemoryTracker())
+	d.Interval, d.MaxBackoff = time.Second, 5*time.Second
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := d.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// policy.go
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Actions are the operations the policy grants to roles.
+const (
+	actionIssueCreate   = "issue.create"
+	actionIssueRead     = "issue.read"
+	actionIssueUpdate   = "issue.update"
+	actionIssuePush     = "issue.push"
+	actionIssueDelete   = "issue.delete"
+	actionIssueLog      = "issue.log"
+	actionErrorRead     = "error.read"
+	actionErrorWrite    = "error.write"
+	actionRoutingTest   = "routing.test"
+	actionTrackerStatus = "tracker.status"
+	actionJobRead       = "job.read"
+	actionMetricsRead   = "metrics.read"
+)
+
+var policyActions = map[string]bool{
+	actionIssueCreate: true, actionIssueRead: true, actionIssueUpdate: true, actionIssuePush: true, actionIssueDelete: true, actionIssueLog: true,
+	actionErrorRead: true, actionErrorWrite: true, actionRoutingTest: true, actionTrackerStatus: true, actionJobRead: true, actionMetricsRead: true,
+}
+
+const policyReloadInterval = 10 * time.Second
+
+const (
+	roleReporter  = "reporter"
+	roleSupporter = "supporter"
+	roleAdmin     = "admin"
+)
+
+// PolicyConfig maps each role to the actions it may perform. "*" grants
+// every action.
+type PolicyConfig struct {
+	Roles map[string][]string `yaml:"roles" json:"roles"`
+}
+
+func defaultPolicyConfig() PolicyConfig {
+	return PolicyConfig{Roles: map[string][]string{
+		roleReporter:  {actionIssueCreate, actionIssueRead, actionErrorRead, actionRoutingTest},
+		roleSupporter: {actionIssueRead, actionIssueUpdate, actionIssuePush, actionIssueLog, actionErrorRead, actionRoutingTest, actionTrackerStatus, actionJobRead, actionMetricsRead},
+		roleAdmin:     {"*"},
+	}}
+}
+
+func parsePolicyConfig(data []byte) (PolicyConfig, error) {
+	var config PolicyConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("Unable to parse policy: [%s]", err.Error())
+	}
+	if len(config.Roles) == 0 {
+		return config, fmt.Errorf("Policy must define at least one role")
+	}
+	for role, actions := range config.Roles {
+		for _, action := range actions {
+			if action != "*" && !policyActions[action] {
+				return config, fmt.Errorf("Policy role %s has unknown action %s", role, action)
+			}
+		}
+	}
+	return config, nil
+}
+
+// Policy holds the role grants and reloads them when the file they came from
+// changes.
+type Policy struct {
+	watchedFile
+
+	mu     sync.RWMutex
+	config PolicyConfig
+}
+
+// NewPolicy loads the grants from path, or uses the built-in grants when path
+// is empty.
+func NewPolicy(path string) (*Policy, error) {
+	p := &Policy{config: defaultPolicyConfig()}
+	p.watchedFile = watchedFile{Logger: slog.Default(), what: "policy", path: path, load: p.load}
+	if path == "" {
+		return p, nil
+	}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Policy) load(data []byte) error {
+	config, err := parsePolicyConfig(data)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.config = config
+	p.mu.Unlock()
+	return nil
+}
+
+// Check returns nil when one of roles may perform action, and otherwise an
+// error naming the roles that may.
+func (p *Policy) Check(roles []string, action string) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, role := range roles {
+		for _, granted := range p.config.Roles[role] {
+			if granted == action || granted == "*" {
+				return nil
+			}
+		}
+	}
+	var allowed []string
+	for role, actions := range p.config.Roles {
+		for _, granted := range actions {
+			if granted == action || granted == "*" {
+				allowed = append(allowed, role)
+				break
+			}
+		}
+	}
+	sort.Strings(allowed)
+	have := "no roles"
+	if len(roles) > 0 {
+		have = "roles " + strings.Join(roles, ", ")
+	}
+	if len(allowed) == 0 {
+		return fmt.Errorf("%s requires a role that no policy grants; you have %s", action, have)
+	}
+	return fmt.Errorf("%s requires role %s; you have %s", action, strings.Join(allowed, " or "), have)
+}
+
+// authorize answers 403 and returns false unless the principal of r may
+// perform action. Requests without a principal, when authentication is
+// disabled, are allowed.
+func (a *App) authorize(w http.ResponseWriter, r *http.Request, action string) bool {
+	principal := principalFrom(r.Context())
+	if principal == nil || a.Policy == nil {
+		return true
+	}
+	if err := a.Policy.Check(principal.Roles, action); err != nil {
+		a.Logger.WarnContext(r.Context(), "denied request", "subject", principal.Subject, "action", action, "error", err.Error())
+		respondWithError(w, http.StatusForbidden, "Forbidden: "+err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+// repo.go
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// repo is embedded by the repositories. All queries go through q, which is
+// the open transaction when the repository was made with WithTx.
+type repo struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+func (r repo) q() dbtx {
+	if r.tx != nil {
+		return traced(r.tx)
+	}
+	return traced(r.db)
+}
+
+// traced wraps q so that every statement carries the request ID of its
+// context as a SQL comment, which shows up in pg_stat_activity and the
+// Postgres logs, and is logged at debug level with its duration.
+func traced(q dbtx) dbtx {
+	return tracedDB{q}
+}
+
+type tracedDB struct {
+	q dbtx
+}
+
+func (t tracedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer logQuery(ctx, query, time.Now())
+	return t.q.ExecContext(ctx, tagQuery(ctx, query), args...)
+}
+
+func (t tracedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer logQuery(ctx, query, time.Now())
+	return t.q.QueryContext(ctx, tagQuery(ctx, query), args...)
+}
+
+func (t tracedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	defer logQuery(ctx, query, time.Now())
+	return t.q.QueryRowContext(ctx, tagQuery(ctx, query), args...)
+}
+
+func tagQuery(ctx context.Context, query string) string {
+	if id := requestIDFrom(ctx); id != "" {
+		return "/* request_id=" + id + " */ " + query
+	}
+	return query
+}
+
+// logQuery logs the statement without its arguments, which hold issue
+// content.
+func logQuery(ctx context.Context, query string, start time.Time) {
+	if !slog.Default().Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	operation := query
+	if i := strings.IndexByte(operation, ' '); i > 0 {
+		operation = operation[:i]
+	}
+	slog.DebugContext(ctx, "db query", "operation", strings.ToUpper(operation), "query", query, "duration_ms", time.Since(start).Milliseconds())
+}
+
+// inTx runs fn in the repository's transaction, or in a new one that is
+// committed when fn succeeds.
+func (r repo) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	if r.tx != nil {
+		return fn(r.tx)
+	}
+	start := time.Now()
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		slog.DebugContext(ctx, "db transaction rolled back", "duration_ms", time.Since(start).Milliseconds(), "error", err.Error())
+		return err
+	}
+	err = tx.Commit()
+	slog.DebugContext(ctx, "db transaction committed", "duration_ms", time.Since(start).Milliseconds())
+	return err
+}
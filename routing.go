@@ -0,0 +1,195 @@
+// routing.go
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const routingReloadInterval = 10 * time.Second
+
+// RoutingMatch selects the issues a rule applies to. Empty fields match
+// anything; service, region and tenant are compared case-insensitively.
+type RoutingMatch struct {
+	ErrorCodePrefix string `yaml:"errorCodePrefix" json:"errorCodePrefix,omitempty"`
+	ErrorCodeRegex  string `yaml:"errorCodeRegex" json:"errorCodeRegex,omitempty"`
+	Service         string `yaml:"service" json:"service,omitempty"`
+	Region          string `yaml:"region" json:"region,omitempty"`
+	Tenant          string `yaml:"tenant" json:"tenant,omitempty"`
+
+	errorCodeRegex *regexp.Regexp
+}
+
+type RoutingRule struct {
+	Name      string       `yaml:"name" json:"name"`
+	Match     RoutingMatch `yaml:"match" json:"match"`
+	ProjectID string       `yaml:"project" json:"project"`
+	IssueType string       `yaml:"issueType" json:"issueType"`
+	Assignee  string       `yaml:"assignee" json:"assignee"`
+	Priority  string       `yaml:"priority" json:"priority"`
+	Labels    []string     `yaml:"labels" json:"labels"`
+}
+
+// RoutingConfig is the content of the routing file. Rules are evaluated in
+// order and the first match wins; Defaults fills in whatever the matching
+// rule leaves empty and is used on its own when nothing matches.
+type RoutingConfig struct {
+	Defaults RoutingRule   `yaml:"defaults" json:"defaults"`
+	Rules    []RoutingRule `yaml:"rules" json:"rules"`
+}
+
+type RouteInput struct {
+	ErrorCode string `json:"errorCode"`
+	Service   string `json:"service"`
+	Region    string `json:"region"`
+	Tenant    string `json:"tenant"`
+}
+
+// Route is where an issue should be filed.
+type Route struct {
+	Rule      string   `json:"rule"`
+	ProjectID string   `json:"project"`
+	IssueType string   `json:"issueType"`
+	Assignee  string   `json:"assignee"`
+	Priority  string   `json:"priority"`
+	Labels    []string `json:"labels"`
+}
+
+func defaultRoutingConfig() RoutingConfig {
+	return RoutingConfig{
+		Defaults: RoutingRule{Name: "default", ProjectID: "10004", IssueType: "10004", Assignee: "xplat"},
+		Rules: []RoutingRule{
+			{Name: "vm", Match: RoutingMatch{ErrorCodePrefix: "vm_"}, ProjectID: "10000"},
+			{Name: "db", Match: RoutingMatch{ErrorCodePrefix: "db_"}, ProjectID: "10002"},
+			{Name: "k8s", Match: RoutingMatch{ErrorCodePrefix: "k8s_"}, ProjectID: "10001"},
+			{Name: "api", Match: RoutingMatch{ErrorCodePrefix: "api_"}, ProjectID: "10003"},
+		},
+	}
+}
+
+// parseRoutingConfig reads a routing file. YAML is a superset of JSON so
+// both formats are accepted.
+func parseRoutingConfig(data []byte) (RoutingConfig, error) {
+	var config RoutingConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("Unable to parse routing rules: [%s]", err.Error())
+	}
+	if config.Defaults.ProjectID == "" || config.Defaults.IssueType == "" {
+		return config, fmt.Errorf("Routing defaults must set project and issueType")
+	}
+	if config.Defaults.Name == "" {
+		config.Defaults.Name = "default"
+	}
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if rule.Match.ErrorCodeRegex != "" {
+			re, err := regexp.Compile(rule.Match.ErrorCodeRegex)
+			if err != nil {
+				return config, fmt.Errorf("Routing rule %s has an invalid errorCodeRegex: [%s]", rule.Name, err.Error())
+			}
+			rule.Match.errorCodeRegex = re
+		}
+	}
+	return config, nil
+}
+
+func (m RoutingMatch) matches(in RouteInput) bool {
+	if m.ErrorCodePrefix != "" && !strings.HasPrefix(in.ErrorCode, m.ErrorCodePrefix) {
+		return false
+	}
+	if m.errorCodeRegex != nil && !m.errorCodeRegex.MatchString(in.ErrorCode) {
+		return false
+	}
+	if m.Service != "" && !strings.EqualFold(m.Service, in.Service) {
+		return false
+	}
+	if m.Region != "" && !strings.EqualFold(m.Region, in.Region) {
+		return false
+	}
+	if m.Tenant != "" && !strings.EqualFold(m.Tenant, in.Tenant) {
+		return false
+	}
+	return true
+}
+
+// RoutingTable holds the routing rules and reloads them when the file they
+// came from changes.
+type RoutingTable struct {
+	watchedFile
+
+	mu     sync.RWMutex
+	config RoutingConfig
+}
+
+// NewRoutingTable loads rules from path, or uses the built-in rules when path
+// is empty.
+func NewRoutingTable(path string) (*RoutingTable, error) {
+	t := &RoutingTable{config: defaultRoutingConfig()}
+	t.watchedFile = watchedFile{Logger: slog.Default(), what: "routing rules", path: path, load: t.load}
+	if path == "" {
+		return t, nil
+	}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *RoutingTable) load(data []byte) error {
+	config, err := parseRoutingConfig(data)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.config = config
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *RoutingTable) Route(in RouteInput) Route {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	defaults := t.config.Defaults
+	rule := defaults
+	for _, candidate := range t.config.Rules {
+		if candidate.Match.matches(in) {
+			rule = candidate
+			break
+		}
+	}
+
+	route := Route{
+		Rule:      rule.Name,
+		ProjectID: rule.ProjectID,
+		IssueType: rule.IssueType,
+		Assignee:  rule.Assignee,
+		Priority:  rule.Priority,
+		Labels:    rule.Labels,
+	}
+	if route.ProjectID == "" {
+		route.ProjectID = defaults.ProjectID
+	}
+	if route.IssueType == "" {
+		route.IssueType = defaults.IssueType
+	}
+	if route.Assignee == "" {
+		route.Assignee = defaults.Assignee
+	}
+	if route.Priority == "" {
+		route.Priority = defaults.Priority
+	}
+	if route.Labels == nil {
+		route.Labels = defaults.Labels
+	}
+	return route
+}
@@ -0,0 +1,59 @@
+// server.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ServerConfig configures the HTTP server. TLS is served when both
+// TLSCertFile and TLSKeyFile are set.
+type ServerConfig struct {
+	Addr              string        `yaml:"addr" env:"APP_ADDR"`
+	ReadTimeout       time.Duration `yaml:"readTimeout" env:"APP_HTTP_READ_TIMEOUT"`
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout" env:"APP_HTTP_READ_HEADER_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout" env:"APP_HTTP_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout" env:"APP_HTTP_IDLE_TIMEOUT"`
+	ShutdownTimeout   time.Duration `yaml:"shutdownTimeout" env:"APP_SHUTDOWN_TIMEOUT"`
+	TLSCertFile       string        `yaml:"tlsCertFile" env:"APP_TLS_CERT_FILE"`
+	TLSKeyFile        string        `yaml:"tlsKeyFile" env:"APP_TLS_KEY_FILE"`
+}
+
+func defaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Addr:              ":8010",
+		ReadTimeout:       15 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		ShutdownTimeout:   20 * time.Second,
+	}
+}
+
+func (c ServerConfig) httpServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              c.Addr,
+		Handler:           handler,
+		ReadTimeout:       c.ReadTimeout,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+	}
+}
+
+// serve runs server until it is shut down, which is not reported as an
+// error.
+func (c ServerConfig) serve(server *http.Server) error {
+	var err error
+	if c.TLSCertFile != "" || c.TLSKeyFile != "" {
+		err = server.ListenAndServeTLS(c.TLSCertFile, c.TLSKeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+// step_log_repo.go
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// StepLogRepo is not tenant scoped: step_log has no tenant, so callers only
+// reach it through an issue IssueRepo found in their scope.
+type StepLogRepo struct {
+	repo
+}
+
+func NewStepLogRepo(db *sql.DB) *StepLogRepo {
+	return &StepLogRepo{repo{db: db}}
+}
+
+func (r *StepLogRepo) WithTx(tx *sql.Tx) *StepLogRepo {
+	return &StepLogRepo{repo{db: r.db, tx: tx}}
+}
+
+// Add inserts stepLog and sets its ID. CreatedAt and UpdatedAt default to
+// now when they are zero.
+func (r *StepLogRepo) Add(ctx context.Context, stepLog *StepLog) error {
+	now := time.Now()
+	if stepLog.CreatedAt.IsZero() {
+		stepLog.CreatedAt = now
+	}
+	if stepLog.UpdatedAt.IsZero() {
+		stepLog.UpdatedAt = now
+	}
+	return r.q().QueryRowContext(ctx, "INSERT INTO step_log(issue_id, reporter_name, supporter_name, description, supporter_jira, status, created_at, updated_at) VALUES($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+		stepLog.IssueID, stepLog.ReporterName, stepLog.SupporterName, stepLog.Description, stepLog.SupporterJira, stepLog.Status,
+		stepLog.CreatedAt, stepLog.UpdatedAt).Scan(&stepLog.ID)
+}
+
+func (r *StepLogRepo) ListByIssueJiraID(ctx context.Context, issueJiraID string) ([]LogIssueResponse, error) {
+	rows, err := r.q().QueryContext(ctx, "SELECT id, issue_id, reporter_name, supporter_name, description, status FROM step_log WHERE issue_id=$1 ORDER BY id",
+		issueJiraID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	logs := []LogIssueResponse{}
+	for rows.Next() {
+		var log LogIssueResponse
+		if err := rows.Scan(&log.Id, &log.IssueId, &log.ReporterName, &log.SupporterName, &log.Description, &log.Status); err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, rows.Err()
+}
+
+// Reassign moves the step_log entries of one issue_id to another, e.g. from
+// the placeholder of a pending issue to its Jira id.
+func (r *StepLogRepo) Reassign(ctx context.Context, fromIssueID, toIssueID string) error {
+	_, err := r.q().ExecContext(ctx, "UPDATE step_log SET issue_id=$1 WHERE issue_id=$2", toIssueID, fromIssueID)
+	return err
+}
@@ -0,0 +1,228 @@
+// sync.go
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncEntry is what the sync worker knows about one tracked issue.
+type SyncEntry struct {
+	IssueID      int        `json:"issueId"`
+	TenantID     string     `json:"tenantId"`
+	IssueJiraID  string     `json:"issueJiraID"`
+	IssueJiraKey string     `json:"issueJiraKey"`
+	Status       string     `json:"status"`
+	LastSync     *time.Time `json:"lastSync,omitempty"`
+	LastAttempt  *time.Time `json:"lastAttempt,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+	Failures     int        `json:"failures"`
+	NextAttempt  time.Time  `json:"nextAttempt"`
+}
+
+// issue is the tracked issue as far as tenantScope.audit needs it.
+func (e SyncEntry) issue() Issues {
+	issue := Issues{TenantID: e.TenantID, IssueJiraID: e.IssueJiraID, IssueJiraKey: e.IssueJiraKey}
+	issue.ID = e.IssueID
+	return issue
+}
+
+// SyncRegistry records the issues the sync worker is tracking, keyed by
+// Jira id.
+type SyncRegistry struct {
+	mu      sync.RWMutex
+	entries map[string]*SyncEntry
+}
+
+func NewSyncRegistry() *SyncRegistry {
+	return &SyncRegistry{entries: map[string]*SyncEntry{}}
+}
+
+// Get returns the entry of the issue with the given Jira id or key.
+func (r *SyncRegistry) Get(issueJiraRef string) (SyncEntry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if entry, ok := r.entries[issueJiraRef]; ok {
+		return *entry, true
+	}
+	for _, entry := range r.entries {
+		if entry.IssueJiraKey != "" && entry.IssueJiraKey == issueJiraRef {
+			return *entry, true
+		}
+	}
+	return SyncEntry{}, false
+}
+
+func (r *SyncRegistry) List() []SyncEntry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entries := make([]SyncEntry, 0, len(r.entries))
+	for _, entry := range r.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].IssueID < entries[j].IssueID })
+	return entries
+}
+
+// track returns the entry for issue, creating it if needed. Callers must
+// hold r.mu.
+func (r *SyncRegistry) track(issue Issues) *SyncEntry {
+	entry, ok := r.entries[issue.IssueJiraID]
+	if !ok {
+		entry = &SyncEntry{IssueID: issue.ID, IssueJiraID: issue.IssueJiraID}
+		r.entries[issue.IssueJiraID] = entry
+	}
+	entry.TenantID = issue.TenantID
+	entry.IssueJiraKey = issue.IssueJiraKey
+	entry.Status = issue.Status
+	return entry
+}
+
+// due reports whether issue is out of its backoff window.
+func (r *SyncRegistry) due(issue Issues, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !now.Before(r.track(issue).NextAttempt)
+}
+
+func (r *SyncRegistry) succeeded(issue Issues, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry := r.track(issue)
+	entry.LastSync = &now
+	entry.LastAttempt = &now
+	entry.LastError = ""
+	entry.Failures = 0
+	entry.NextAttempt = time.Time{}
+}
+
+func (r *SyncRegistry) failed(issue Issues, now time.Time, err error, backoff func(failures int) time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry := r.track(issue)
+	entry.LastAttempt = &now
+	entry.LastError = err.Error()
+	entry.Failures++
+	entry.NextAttempt = now.Add(backoff(entry.Failures))
+}
+
+// prune drops entries for issues that are no longer open.
+func (r *SyncRegistry) prune(open map[string]bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for issueJiraID := range r.entries {
+		if !open[issueJiraID] {
+			delete(r.entries, issueJiraID)
+		}
+	}
+}
+
+// SyncWorker periodically copies the status of every open issue from the
+// tracker into the issues table.
+type SyncWorker struct {
+	Issues      IssueStore
+	Tracker     Tracker
+	Registry    *SyncRegistry
+	Logger      *slog.Logger
+	Interval    time.Duration
+	BatchSize   int
+	CallTimeout time.Duration
+	MaxBackoff  time.Duration
+}
+
+func NewSyncWorker(issues IssueStore, tracker Tracker) *SyncWorker {
+	return &SyncWorker{
+		Issues:      issues,
+		Tracker:     tracker,
+		Registry:    NewSyncRegistry(),
+		Logger:      slog.Default(),
+		Interval:    30 * time.Second,
+		BatchSize:   100,
+		CallTimeout: 10 * time.Second,
+		MaxBackoff:  30 * time.Minute,
+	}
+}
+
+// Run syncs all open issues every Interval until ctx is cancelled.
+func (w *SyncWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		if err := w.SyncAll(ctx); err != nil && ctx.Err() == nil {
+			w.Logger.ErrorContext(ctx, "unable to sync issues with tracker", "error", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncAll walks the open issues in batches of BatchSize and syncs the ones
+// that are not backing off.
+func (w *SyncWorker) SyncAll(ctx context.Context) error {
+	open := map[string]bool{}
+	afterID := 0
+	for {
+		batch, err := w.Issues.ListOpen(ctx, afterID, w.BatchSize)
+		if err != nil {
+			return err
+		}
+		for _, issue := range batch {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			open[issue.IssueJiraID] = true
+			w.syncIssue(ctx, issue)
+		}
+		if len(batch) < w.BatchSize {
+			break
+		}
+		afterID = batch[len(batch)-1].ID
+	}
+	w.Registry.prune(open)
+	return nil
+}
+
+func (w *SyncWorker) syncIssue(ctx context.Context, issue Issues) {
+	if !w.Registry.due(issue, time.Now()) {
+		return
+	}
+	callCtx, cancel := context.WithTimeout(ctx, w.CallTimeout)
+	defer cancel()
+
+	status, err := w.Tracker.GetStatus(callCtx, issue.IssueJiraID)
+	if err == nil && !strings.EqualFold(status, issue.Status) {
+		err = w.Issues.UpdateStatus(ctx, &issue, StatusChange{
+			Status:        status,
+			ReporterName:  "jira",
+			SupporterName: "sync",
+			Description:   fmt.Sprintf("Status changed from %s to %s", issue.Status, status),
+		})
+	}
+	if err != nil {
+		w.Logger.WarnContext(ctx, "unable to sync issue", "issue_jira_id", issue.IssueJiraID, "error", err.Error())
+		w.Registry.failed(issue, time.Now(), err, w.backoff)
+		return
+	}
+	w.Registry.succeeded(issue, time.Now())
+}
+
+// backoff doubles the wait after each consecutive failure, up to MaxBackoff.
+func (w *SyncWorker) backoff(failures int) time.Duration {
+	backoff := w.Interval
+	for i := 1; i < failures && backoff < w.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > w.MaxBackoff {
+		backoff = w.MaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,220 @@
+// sync_test.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// memoryIssueStore keeps issues in a map in place of IssueRepo.
+type memoryIssueStore struct {
+	issues  map[int]Issues
+	changes []StatusChange
+	// updateErr is returned by UpdateStatus when set.
+	updateErr error
+}
+
+func newMemoryIssueStore() *memoryIssueStore {
+	return &memoryIssueStore{issues: map[int]Issues{}}
+}
+
+func (s *memoryIssueStore) add(issue Issues) {
+	s.issues[issue.ID] = issue
+}
+
+func (s *memoryIssueStore) GetByID(ctx context.Context, id int) (Issues, error) {
+	issue, ok := s.issues[id]
+	if !ok {
+		return issue, ErrIssueNotFound
+	}
+	return issue, nil
+}
+
+func (s *memoryIssueStore) ListOpen(ctx context.Context, afterID, limit int) ([]Issues, error) {
+	open := []Issues{}
+	for _, issue := range s.issues {
+		closed := false
+		for _, status := range closedStatuses {
+			closed = closed || strings.EqualFold(issue.Status, status)
+		}
+		if issue.ID > afterID && issue.IssueJiraID != "" && !closed {
+			open = append(open, issue)
+		}
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i].ID < open[j].ID })
+	if len(open) > limit {
+		open = open[:limit]
+	}
+	return open, nil
+}
+
+func (s *memoryIssueStore) UpdateStatus(ctx context.Context, issue *Issues, change StatusChange) error {
+	if s.updateErr != nil {
+		return s.updateErr
+	}
+	stored, ok := s.issues[issue.ID]
+	if !ok {
+		return ErrIssueNotFound
+	}
+	stored.Status = change.Status
+	s.issues[issue.ID] = stored
+	*issue = stored
+	s.changes = append(s.changes, change)
+	return nil
+}
+
+// jiraIssue stores issue with the given Jira id and status.
+func jiraIssue(id int, issueJiraID, status string) Issues {
+	issue := pendingIssue(id)
+	issue.IssueJiraID = issueJiraID
+	issue.Status = status
+	issue.SyncState = syncStateSynced
+	return issue
+}
+
+func TestSyncWorkerSyncIssue(t *testing.T) {
+	tests := []struct {
+		name        string
+		issue       Issues
+		jiraStatus  string // empty when the issue is not in Jira
+		updateErr   error
+		wantStatus  string
+		wantChange  string
+		wantFailure bool
+	}{
+		{
+			name:       "copies a new status from Jira",
+			issue:      jiraIssue(1, "10001", "TO DO"),
+			jiraStatus: "In Progress",
+			wantStatus: "In Progress",
+			wantChange: "Status changed from TO DO to In Progress",
+		},
+		{
+			name:       "ignores a difference in case",
+			issue:      jiraIssue(1, "10001", "TO DO"),
+			jiraStatus: "To Do",
+			wantStatus: "TO DO",
+		},
+		{
+			name:        "issue is missing in Jira",
+			issue:       jiraIssue(1, "10001", "TO DO"),
+			wantStatus:  "TO DO",
+			wantFailure: true,
+		},
+		{
+			name:        "status cannot be stored",
+			issue:       jiraIssue(1, "10001", "TO DO"),
+			jiraStatus:  "Done",
+			updateErr:   errors.New("connection refused"),
+			wantStatus:  "TO DO",
+			wantFailure: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues := newMemoryIssueStore()
+			issues.add(tt.issue)
+			issues.updateErr = tt.updateErr
+			tracker := NewMemoryTracker()
+			if tt.jiraStatus != "" {
+				created, _ := tracker.CreateIssue(context.Background(), TrackerIssue{Summary: tt.issue.Name})
+				tracker.Transition(context.Background(), created.ID, tt.jiraStatus)
+			}
+			w := NewSyncWorker(issues, tracker)
+
+			w.syncIssue(context.Background(), tt.issue)
+
+			if got := issues.issues[1].Status; got != tt.wantStatus {
+				t.Errorf("status = %q, want %q", got, tt.wantStatus)
+			}
+			switch {
+			case tt.wantChange == "" && len(issues.changes) != 0:
+				t.Errorf("changes = %+v, want none", issues.changes)
+			case tt.wantChange != "" && (len(issues.changes) != 1 || issues.changes[0].Description != tt.wantChange):
+				t.Errorf("changes = %+v, want %q", issues.changes, tt.wantChange)
+			}
+			entry, ok := w.Registry.Get(tt.issue.IssueJiraID)
+			if !ok {
+				t.Fatalf("issue %s is not tracked", tt.issue.IssueJiraID)
+			}
+			if tt.wantFailure {
+				if entry.Failures != 1 || entry.LastError == "" || entry.LastSync != nil {
+					t.Errorf("entry = %+v, want one failure", entry)
+				}
+				return
+			}
+			if entry.Failures != 0 || entry.LastSync == nil {
+				t.Errorf("entry = %+v, want synced", entry)
+			}
+		})
+	}
+}
+
+func TestSyncWorkerBacksOff(t *testing.T) {
+	issues := newMemoryIssueStore()
+	issue := jiraIssue(1, "10001", "TO DO")
+	issues.add(issue)
+	tracker := NewMemoryTracker()
+	w := NewSyncWorker(issues, tracker)
+
+	w.syncIssue(context.Background(), issue)
+	created, _ := tracker.CreateIssue(context.Background(), TrackerIssue{Summary: issue.Name})
+	tracker.Transition(context.Background(), created.ID, "In Progress")
+	w.syncIssue(context.Background(), issue)
+
+	entry, _ := w.Registry.Get(issue.IssueJiraID)
+	if entry.Failures != 1 || len(issues.changes) != 0 {
+		t.Fatalf("entry = %+v, changes = %+v; want the second attempt to wait", entry, issues.changes)
+	}
+	if wait := entry.NextAttempt.Sub(*entry.LastAttempt); wait != w.Interval {
+		t.Errorf("backoff = %s, want %s", wait, w.Interval)
+	}
+}
+
+func TestSyncWorkerSyncAll(t *testing.T) {
+	issues := newMemoryIssueStore()
+	tracker := NewMemoryTracker()
+	for id := 1; id <= 3; id++ {
+		created, _ := tracker.CreateIssue(context.Background(), TrackerIssue{})
+		tracker.Transition(context.Background(), created.ID, "In Progress")
+		issues.add(jiraIssue(id, created.ID, "TO DO"))
+	}
+	w := NewSyncWorker(issues, tracker)
+	w.BatchSize = 2
+
+	if err := w.SyncAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for id, issue := range issues.issues {
+		if issue.Status != "In Progress" {
+			t.Errorf("issue %d status = %q, want In Progress", id, issue.Status)
+		}
+	}
+	if got := len(w.Registry.List()); got != 3 {
+		t.Fatalf("tracked %d issues, want 3", got)
+	}
+
+	closed := issues.issues[2]
+	closed.Status = "DONE"
+	issues.add(closed)
+	if err := w.SyncAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.Registry.Get(closed.IssueJiraID); ok {
+		t.Errorf("closed issue %s is still tracked", closed.IssueJiraID)
+	}
+	if got := len(w.Registry.List()); got != 2 {
+		t.Errorf("tracked %d issues, want 2", got)
+	}
+}
+
+// Both workers only need the interfaces, not Postgres.
+var (
+	_ IssueStore  = (*IssueRepo)(nil)
+	_ OutboxStore = (*OutboxRepo)(nil)
+	_ Tracker     = failingTracker{}
+)
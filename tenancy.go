@@ -0,0 +1,135 @@
+// tenancy.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// ErrTenantScope is returned by IssueRepo when the context may not touch the
+// issues asked for, or any issues at all.
+var ErrTenantScope = errors.New("outside tenant scope")
+
+// roleOperator lets a principal's other roles act on the issues of every
+// tenant. Such accesses are audit logged.
+const roleOperator = "operator"
+
+type systemScopeKey struct{}
+
+// withSystemScope marks ctx as acting for the service itself, such as the
+// background workers and the Jira webhook, which see every tenant.
+func withSystemScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, systemScopeKey{}, true)
+}
+
+// tenantScope limits the issues IssueRepo reads and writes.
+type tenantScope struct {
+	all    bool
+	tenant string
+	// operator is set when all comes from roleOperator.
+	operator *Principal
+}
+
+// tenantScopeFrom is every tenant for the system and for operators, and the
+// principal's tenant otherwise. Contexts with neither have no scope.
+func tenantScopeFrom(ctx context.Context) (tenantScope, error) {
+	if system, _ := ctx.Value(systemScopeKey{}).(bool); system {
+		return tenantScope{all: true}, nil
+	}
+	p := principalFrom(ctx)
+	switch {
+	case p == nil:
+		return tenantScope{}, fmt.Errorf("%w: request is not authenticated", ErrTenantScope)
+	case p.hasRole(roleOperator):
+		return tenantScope{all: true, operator: p}, nil
+	case p.TenantID != "":
+		return tenantScope{tenant: p.TenantID}, nil
+	default:
+		return tenantScope{}, fmt.Errorf("%w: %s belongs to no tenant", ErrTenantScope, p.Name)
+	}
+}
+
+// and returns the condition restricting the issues table to the scope, to be
+// appended to a WHERE clause, with its argument added to args.
+func (s tenantScope) and(args []interface{}) (string, []interface{}) {
+	if s.all {
+		return "", args
+	}
+	args = append(args, s.tenant)
+	return " AND tenant_id=$" + strconv.Itoa(len(args)), args
+}
+
+// where adds the scope's condition to a WHERE clause that may be empty.
+func (s tenantScope) where(where string, args []interface{}) (string, []interface{}) {
+	condition, args := s.and(args)
+	if condition == "" || where != "" {
+		return where + condition, args
+	}
+	return " WHERE" + condition[len(" AND"):], args
+}
+
+// check returns ErrTenantScope unless issues of tenantID are in the scope.
+func (s tenantScope) check(tenantID string) error {
+	if s.all || tenantID == s.tenant {
+		return nil
+	}
+	return fmt.Errorf("%w: not allowed to access issues of tenant %s", ErrTenantScope, tenantID)
+}
+
+// audit logs an operator's access to issues of tenants other than their own.
+func (s tenantScope) audit(ctx context.Context, operation string, issues ...Issues) {
+	if s.operator == nil {
+		return
+	}
+	tenants := map[string]bool{}
+	var ids []int
+	for _, issue := range issues {
+		if issue.TenantID != s.operator.TenantID {
+			tenants[issue.TenantID] = true
+			ids = append(ids, issue.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	tenantIDs := make([]string, 0, len(tenants))
+	for tenant := range tenants {
+		tenantIDs = append(tenantIDs, tenant)
+	}
+	sort.Strings(tenantIDs)
+	slog.InfoContext(ctx, "cross-tenant access",
+		"audit", true,
+		"subject", s.operator.Subject,
+		"operator", s.operator.Name,
+		"operation", operation,
+		"tenant_ids", tenantIDs,
+		"issue_ids", ids)
+}
+
+func (p *Principal) hasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// issueErrorStatus maps an error returned by IssueRepo to the status code
+// our own API should answer with.
+func issueErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrIssueNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrTenantScope):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,219 @@
+// tracker.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Tracker is the issue tracker that issues are pushed to. JiraTracker talks
+// to a Jira server, MemoryTracker keeps everything in process.
+//
+// When issue.IdempotencyKey is set, CreateIssue returns the issue created
+// earlier with the same key instead of creating a second one.
+type Tracker interface {
+	CreateIssue(ctx context.Context, issue TrackerIssue) (*CreatedIssue, error)
+	GetStatus(ctx context.Context, issueID string) (string, error)
+	AddComment(ctx context.Context, issueID, body string) error
+	Transition(ctx context.Context, issueID, status string) error
+	Close(ctx context.Context, issueID string) error
+	Health() TrackerHealth
+	// Ping checks that the tracker can be reached with our credentials.
+	Ping(ctx context.Context) error
+}
+
+// TrackerHealth is reported at GET /tracker/status.
+type TrackerHealth struct {
+	Tracker string         `json:"tracker"`
+	BaseURL string         `json:"baseUrl,omitempty"`
+	Breaker *BreakerStatus `json:"breaker,omitempty"`
+}
+
+type TrackerIssue struct {
+	ProjectID   string   `json:"projectId"`
+	IssueType   string   `json:"issueType"`
+	Assignee    string   `json:"assignee"`
+	Priority    string   `json:"priority"`
+	Labels      []string `json:"labels"`
+	Reporter    string   `json:"reporter"`
+	Summary     string   `json:"summary"`
+	Description string   `json:"description"`
+	Environment string   `json:"environment"`
+
+	// RoutingRule names the routing rule that picked the project.
+	RoutingRule    string `json:"routingRule,omitempty"`
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+}
+
+// idempotencyLabel is the tracker label that carries an idempotency key.
+func idempotencyLabel(key string) string {
+	return "idem-" + key
+}
+
+// CreatedIssue identifies an issue created in the tracker by both its
+// numeric id and its human readable key (e.g. "K8S-123").
+type CreatedIssue struct {
+	ID   string `json:"id"`
+	Key  string `json:"key"`
+	Self string `json:"self"`
+}
+
+// ErrTrackerUnavailable wraps errors where the tracker could not be reached
+// at all, as opposed to the tracker rejecting the request.
+var ErrTrackerUnavailable = errors.New("tracker unavailable")
+
+// trackerHTTPStatus maps an error returned by a Tracker to the status code
+// our own API should answer with.
+func trackerHTTPStatus(err error) int {
+	var jiraErr *JiraError
+	switch {
+	case errors.As(err, &jiraErr):
+		switch {
+		case jiraErr.StatusCode == http.StatusBadRequest:
+			return http.StatusBadRequest
+		case jiraErr.StatusCode == http.StatusNotFound:
+			return http.StatusNotFound
+		case jiraErr.StatusCode == http.StatusTooManyRequests:
+			return http.StatusServiceUnavailable
+		default:
+			return http.StatusBadGateway
+		}
+	case errors.Is(err, ErrTrackerUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func newTracker(config TrackerConfig) Tracker {
+	switch config.Kind {
+	case "memory":
+		return NewMemoryTracker()
+	default:
+		tracker := NewJiraTracker(config.Jira.URL, config.Jira.Username, config.Jira.Token)
+		tracker.CloseStatus = config.Jira.CloseStatus
+		return tracker
+	}
+}
+
+// summarize turns issue content into a one line summary short enough for
+// the tracker.
+func summarize(content string) string {
+	summary := strings.TrimSpace(content)
+	if i := strings.IndexAny(summary, "\r\n"); i >= 0 {
+		summary = summary[:i]
+	}
+	if len(summary) > 255 {
+		summary = summary[:252] + "..."
+	}
+	return summary
+}
+
+type MemoryIssue struct {
+	TrackerIssue
+	ID       string   `json:"id"`
+	Key      string   `json:"key"`
+	Status   string   `json:"status"`
+	Comments []string `json:"comments"`
+}
+
+type MemoryTracker struct {
+	mu     sync.Mutex
+	nextID int
+	Issues map[string]*MemoryIssue
+}
+
+func NewMemoryTracker() *MemoryTracker {
+	return &MemoryTracker{
+		nextID: 10000,
+		Issues: map[string]*MemoryIssue{},
+	}
+}
+
+func (t *MemoryTracker) CreateIssue(ctx context.Context, issue TrackerIssue) (*CreatedIssue, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if issue.IdempotencyKey != "" {
+		for _, existing := range t.Issues {
+			if existing.IdempotencyKey == issue.IdempotencyKey {
+				return &CreatedIssue{ID: existing.ID, Key: existing.Key, Self: "memory://issue/" + existing.ID}, nil
+			}
+		}
+	}
+	t.nextID++
+	id := strconv.Itoa(t.nextID)
+	created := &MemoryIssue{
+		TrackerIssue: issue,
+		ID:           id,
+		Key:          fmt.Sprintf("MEM-%d", t.nextID-10000),
+		Status:       "To Do",
+	}
+	t.Issues[id] = created
+	return &CreatedIssue{ID: created.ID, Key: created.Key, Self: "memory://issue/" + id}, nil
+}
+
+// issue looks an issue up by id or key.
+func (t *MemoryTracker) issue(issueID string) (*MemoryIssue, error) {
+	if issue, ok := t.Issues[issueID]; ok {
+		return issue, nil
+	}
+	for _, issue := range t.Issues {
+		if issue.Key == issueID {
+			return issue, nil
+		}
+	}
+	return nil, &JiraError{
+		StatusCode:    http.StatusNotFound,
+		ErrorMessages: []string{fmt.Sprintf("Issue %s does not exist", issueID)},
+	}
+}
+
+func (t *MemoryTracker) GetStatus(ctx context.Context, issueID string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	issue, err := t.issue(issueID)
+	if err != nil {
+		return "", err
+	}
+	return issue.Status, nil
+}
+
+func (t *MemoryTracker) AddComment(ctx context.Context, issueID, body string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	issue, err := t.issue(issueID)
+	if err != nil {
+		return err
+	}
+	issue.Comments = append(issue.Comments, body)
+	return nil
+}
+
+func (t *MemoryTracker) Transition(ctx context.Context, issueID, status string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	issue, err := t.issue(issueID)
+	if err != nil {
+		return err
+	}
+	issue.Status = status
+	return nil
+}
+
+func (t *MemoryTracker) Close(ctx context.Context, issueID string) error {
+	return t.Transition(ctx, issueID, "Done")
+}
+
+func (t *MemoryTracker) Health() TrackerHealth {
+	return TrackerHealth{Tracker: "memory"}
+}
+
+func (t *MemoryTracker) Ping(ctx context.Context) error {
+	return nil
+}
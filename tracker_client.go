@@ -0,0 +1,281 @@
+// tracker_client.go
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the tracker while the circuit
+// breaker is open. It wraps ErrTrackerUnavailable.
+var ErrCircuitOpen = fmt.Errorf("circuit breaker is open: %w", ErrTrackerUnavailable)
+
+// TrackerClient is the HTTP client shared by tracker calls. Every attempt has
+// its own timeout, failed attempts are retried with jittered exponential
+// backoff, and a circuit breaker stops calling a tracker that keeps failing.
+type TrackerClient struct {
+	HTTP        *http.Client
+	CallTimeout time.Duration
+	MaxRetries  int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	Breaker     *CircuitBreaker
+	Logger      *slog.Logger
+}
+
+func NewTrackerClient() *TrackerClient {
+	return &TrackerClient{
+		HTTP:        &http.Client{},
+		CallTimeout: 10 * time.Second,
+		MaxRetries:  3,
+		BaseBackoff: 200 * time.Millisecond,
+		MaxBackoff:  5 * time.Second,
+		Breaker:     NewCircuitBreaker(5, 30*time.Second),
+		Logger:      slog.Default(),
+	}
+}
+
+// Do sends the request made by newRequest and returns the status code and
+// body of the response. newRequest is called again for every retry.
+//
+// 429 and 5xx responses are retried, honouring Retry-After up to MaxBackoff;
+// a longer Retry-After fails with ErrTrackerUnavailable. Requests that are
+// not idempotent are only retried on 429 and 503, where the tracker did not
+// act on them, and never after a transport error.
+func (c *TrackerClient) Do(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error)) (int, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest(ctx)
+		if err != nil {
+			return 0, nil, err
+		}
+		if !c.Breaker.Allow() {
+			appMetrics.inc("tracker_request_errors_total", req.Method, trackerPathTemplate(req.URL.Path), "circuit_open")
+			return 0, nil, ErrCircuitOpen
+		}
+		statusCode, header, body, err := c.attempt(ctx, req)
+		retryable := c.retryable(req.Method, statusCode, err)
+		switch {
+		case err != nil && ctx.Err() != nil:
+			// Our caller gave up; that says nothing about the tracker.
+			c.Breaker.Abandon()
+		case err != nil || statusCode == http.StatusTooManyRequests || statusCode >= 500:
+			if c.Breaker.Failure(trackerFailure(statusCode, err)) {
+				c.Logger.ErrorContext(ctx, "opened tracker circuit breaker", "error", trackerFailure(statusCode, err).Error())
+			}
+		default:
+			c.Breaker.Success()
+		}
+		if !retryable || attempt >= c.MaxRetries {
+			if err != nil {
+				return 0, nil, fmt.Errorf("Unable to perform request to Jira: [%s]: %w", err.Error(), ErrTrackerUnavailable)
+			}
+			return statusCode, body, nil
+		}
+
+		wait := c.backoff(attempt + 1)
+		if retryAfter, ok := parseRetryAfter(header.Get("Retry-After"), time.Now()); ok {
+			if retryAfter > c.MaxBackoff {
+				// Waiting that long would hang our own callers.
+				return 0, nil, fmt.Errorf("Jira asked to retry after %s: %w", retryAfter.Round(time.Second), ErrTrackerUnavailable)
+			}
+			wait = retryAfter
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			if err != nil {
+				return 0, nil, fmt.Errorf("Unable to perform request to Jira: [%s]: %w", err.Error(), ErrTrackerUnavailable)
+			}
+			return statusCode, body, nil
+		}
+		c.Logger.WarnContext(ctx, "retrying tracker request", "method", req.Method, "path", req.URL.Path,
+			"attempt", attempt+1, "wait_ms", wait.Milliseconds(), "error", trackerFailure(statusCode, err).Error())
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, nil, fmt.Errorf("Unable to perform request to Jira: [%s]: %w", ctx.Err().Error(), ErrTrackerUnavailable)
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *TrackerClient) attempt(ctx context.Context, req *http.Request) (int, http.Header, []byte, error) {
+	callCtx, cancel := context.WithTimeout(ctx, c.CallTimeout)
+	defer cancel()
+	start := time.Now()
+	statusCode, header, body, err := func() (int, http.Header, []byte, error) {
+		res, err := c.HTTP.Do(req.WithContext(callCtx))
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("Unable to read response body from Jira: [%s]", err.Error())
+		}
+		return res.StatusCode, res.Header, body, nil
+	}()
+	appMetrics.observeTracker(req.Method, req.URL.Path, statusCode, err, time.Since(start))
+	return statusCode, header, body, err
+}
+
+func (c *TrackerClient) retryable(method string, statusCode int, err error) bool {
+	idempotent := method == http.MethodGet || method == http.MethodHead || method == http.MethodPut || method == http.MethodDelete
+	switch {
+	case err != nil:
+		return idempotent
+	case statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable:
+		return true
+	case statusCode >= 500:
+		return idempotent
+	default:
+		return false
+	}
+}
+
+// backoff is BaseBackoff doubled for every retry, capped at MaxBackoff, with
+// full jitter over its upper half.
+func (c *TrackerClient) backoff(retry int) time.Duration {
+	backoff := c.BaseBackoff
+	for i := 1; i < retry && backoff < c.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > c.MaxBackoff {
+		backoff = c.MaxBackoff
+	}
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func trackerFailure(statusCode int, err error) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("Jira returned status %d", statusCode)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if wait := t.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// CircuitBreaker opens after Threshold consecutive failures. While open every
+// call is refused; after OpenTimeout a single probe call is let through and
+// its outcome closes or reopens the breaker.
+type CircuitBreaker struct {
+	Threshold   int
+	OpenTimeout time.Duration
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastError string
+}
+
+// BreakerStatus is a snapshot of a circuit breaker.
+type BreakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	OpenedAt            *time.Time `json:"openedAt,omitempty"`
+	RetryAt             *time.Time `json:"retryAt,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+}
+
+func NewCircuitBreaker(threshold int, openTimeout time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, OpenTimeout: openTimeout, state: breakerClosed}
+}
+
+// Allow reports whether a call may be made now.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.OpenTimeout {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = breakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// Abandon gives back a probe call that ended without an outcome.
+func (b *CircuitBreaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// Failure records a failed call and reports whether it opened the breaker.
+func (b *CircuitBreaker) Failure(err error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastError = err.Error()
+	b.probing = false
+	if b.state != breakerHalfOpen && b.failures < b.Threshold {
+		return false
+	}
+	opened := b.state != breakerOpen
+	b.state = breakerOpen
+	b.openedAt = time.Now()
+	return opened
+}
+
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := BreakerStatus{State: b.state, ConsecutiveFailures: b.failures, LastError: b.lastError}
+	if b.state != breakerClosed {
+		openedAt := b.openedAt
+		retryAt := openedAt.Add(b.OpenTimeout)
+		status.OpenedAt = &openedAt
+		status.RetryAt = &retryAt
+	}
+	return status
+}
@@ -0,0 +1,149 @@
+// tracker_client_test.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	failure := errors.New("Jira returned status 503")
+	type step struct {
+		do        string // allow, success, failure, abandon or wait
+		want      bool   // result of allow, or whether failure opened the breaker
+		wantState string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "opens after threshold consecutive failures",
+			steps: []step{
+				{do: "failure", want: false, wantState: breakerClosed},
+				{do: "failure", want: false, wantState: breakerClosed},
+				{do: "failure", want: true, wantState: breakerOpen},
+				{do: "allow", want: false, wantState: breakerOpen},
+			},
+		},
+		{
+			name: "success resets the failure count",
+			steps: []step{
+				{do: "failure", wantState: breakerClosed},
+				{do: "failure", wantState: breakerClosed},
+				{do: "success", wantState: breakerClosed},
+				{do: "failure", want: false, wantState: breakerClosed},
+				{do: "allow", want: true, wantState: breakerClosed},
+			},
+		},
+		{
+			name: "a successful probe closes it",
+			steps: []step{
+				{do: "failure"}, {do: "failure"}, {do: "failure", want: true, wantState: breakerOpen},
+				{do: "wait"},
+				{do: "allow", want: true, wantState: breakerHalfOpen},
+				{do: "allow", want: false, wantState: breakerHalfOpen},
+				{do: "success", wantState: breakerClosed},
+				{do: "allow", want: true, wantState: breakerClosed},
+			},
+		},
+		{
+			name: "a failed probe reopens it",
+			steps: []step{
+				{do: "failure"}, {do: "failure"}, {do: "failure", want: true, wantState: breakerOpen},
+				{do: "wait"},
+				{do: "allow", want: true, wantState: breakerHalfOpen},
+				{do: "failure", want: true, wantState: breakerOpen},
+				{do: "allow", want: false, wantState: breakerOpen},
+			},
+		},
+		{
+			name: "an abandoned probe lets another one through",
+			steps: []step{
+				{do: "failure"}, {do: "failure"}, {do: "failure", want: true, wantState: breakerOpen},
+				{do: "wait"},
+				{do: "allow", want: true, wantState: breakerHalfOpen},
+				{do: "abandon", wantState: breakerHalfOpen},
+				{do: "allow", want: true, wantState: breakerHalfOpen},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker(3, time.Hour)
+			for i, s := range tt.steps {
+				var got bool
+				switch s.do {
+				case "allow":
+					got = b.Allow()
+				case "success":
+					b.Success()
+				case "failure":
+					got = b.Failure(failure)
+				case "abandon":
+					b.Abandon()
+				case "wait":
+					b.openedAt = b.openedAt.Add(-b.OpenTimeout)
+					continue
+				}
+				if (s.do == "allow" || s.do == "failure") && got != s.want {
+					t.Errorf("step %d %s = %v, want %v", i, s.do, got, s.want)
+				}
+				if s.wantState != "" && b.Status().State != s.wantState {
+					t.Errorf("step %d %s: state = %s, want %s", i, s.do, b.Status().State, s.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestTrackerClientRetryAfter(t *testing.T) {
+	tests := []struct {
+		name         string
+		retryAfter   string
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "short Retry-After is honoured", retryAfter: "0", wantAttempts: 3},
+		{name: "long Retry-After gives up", retryAfter: "3600", wantAttempts: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.Header().Set("Retry-After", tt.retryAfter)
+				w.WriteHeader(http.StatusTooManyRequests)
+			}))
+			defer server.Close()
+
+			client := NewTrackerClient()
+			client.MaxRetries = 2
+			client.Breaker = NewCircuitBreaker(10, time.Minute)
+			start := time.Now()
+			statusCode, _, err := client.Do(context.Background(), func(ctx context.Context) (*http.Request, error) {
+				return http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/rest/api/2/issue", nil)
+			})
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Fatalf("Do() took %s", elapsed)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrTrackerUnavailable) {
+					t.Errorf("Do() error = %v, want ErrTrackerUnavailable", err)
+				}
+				return
+			}
+			if err != nil || statusCode != http.StatusTooManyRequests {
+				t.Errorf("Do() = %d, %v; want %d", statusCode, err, http.StatusTooManyRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// watched_file.go
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// watchedFile is a configuration file that is loaded again whenever it is
+// modified. It is embedded by RoutingTable and Policy, whose load parses the
+// file and swaps in the result.
+type watchedFile struct {
+	Logger *slog.Logger
+
+	// what names the content of the file in log messages.
+	what string
+	path string
+	load func(data []byte) error
+
+	fileMu  sync.Mutex
+	modTime time.Time
+}
+
+func (f *watchedFile) Reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	if err := f.load(data); err != nil {
+		return err
+	}
+	f.fileMu.Lock()
+	f.modTime = info.ModTime()
+	f.fileMu.Unlock()
+	return nil
+}
+
+// Watch reloads the file whenever it is modified, until ctx is cancelled. A
+// file that fails to load is reported and the previous content stays in
+// effect.
+func (f *watchedFile) Watch(ctx context.Context, interval time.Duration) {
+	if f.path == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(f.path)
+		if err != nil {
+			f.Logger.ErrorContext(ctx, "unable to stat "+f.what+" file", "path", f.path, "error", err.Error())
+			continue
+		}
+		f.fileMu.Lock()
+		changed := !info.ModTime().Equal(f.modTime)
+		f.fileMu.Unlock()
+		if !changed {
+			continue
+		}
+		if err := f.Reload(); err != nil {
+			f.Logger.ErrorContext(ctx, "unable to reload "+f.what, "path", f.path, "error", err.Error())
+			continue
+		}
+		f.Logger.InfoContext(ctx, "reloaded "+f.what, "path", f.path)
+	}
+}
@@ -0,0 +1,192 @@
+// webhook.go
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const maxWebhookBody = 1 << 20
+
+type jiraUser struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+func (u jiraUser) String() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	if u.Name != "" {
+		return u.Name
+	}
+	return "jira"
+}
+
+// jiraWebhookEvent is the subset of a Jira webhook payload we act on.
+type jiraWebhookEvent struct {
+	WebhookEvent string   `json:"webhookEvent"`
+	User         jiraUser `json:"user"`
+	Issue        struct {
+		ID     string `json:"id"`
+		Key    string `json:"key"`
+		Fields struct {
+			Status struct {
+				Name string `json:"name"`
+			} `json:"status"`
+		} `json:"fields"`
+	} `json:"issue"`
+	Changelog struct {
+		Items []struct {
+			Field      string `json:"field"`
+			FromString string `json:"fromString"`
+			ToString   string `json:"toString"`
+		} `json:"items"`
+	} `json:"changelog"`
+	Comment struct {
+		Body   string   `json:"body"`
+		Author jiraUser `json:"author"`
+	} `json:"comment"`
+}
+
+// verifyWebhookSecret accepts either an HMAC-SHA256 of the body in
+// X-Hub-Signature, as sent by Jira when a webhook secret is set, or the
+// shared secret itself in X-Webhook-Secret or the secret query parameter for
+// Jira versions that cannot sign.
+func verifyWebhookSecret(r *http.Request, body []byte, secret string) bool {
+	if signature := r.Header.Get("X-Hub-Signature"); signature != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		return hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected))
+	}
+	provided := r.Header.Get("X-Webhook-Secret")
+	if provided == "" {
+		provided = r.URL.Query().Get("secret")
+	}
+	return provided != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(secret)) == 1
+}
+
+func (a *App) jiraWebhook(w http.ResponseWriter, r *http.Request) {
+	if a.WebhookSecret == "" {
+		respondWithError(w, http.StatusServiceUnavailable, "Jira webhook secret is not configured")
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to read webhook body")
+		return
+	}
+	defer r.Body.Close()
+	if !verifyWebhookSecret(r, body, a.WebhookSecret) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid webhook secret")
+		return
+	}
+
+	var event jiraWebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook payload")
+		return
+	}
+
+	if event.Issue.ID == "" && event.Issue.Key == "" {
+		// Pending issues have no Jira id or key either; never match them.
+		respondWithJSON(w, http.StatusOK, map[string]string{"result": "ignored"})
+		return
+	}
+
+	// Jira speaks for every tenant once the secret checks out.
+	r = r.WithContext(withSystemScope(r.Context()))
+	issue, err := a.IssueRepo.GetByJiraRef(r.Context(), event.Issue.ID, event.Issue.Key)
+	if err != nil {
+		if errors.Is(err, ErrIssueNotFound) {
+			// Not an issue we filed; acknowledge so Jira does not retry.
+			respondWithJSON(w, http.StatusOK, map[string]string{"result": "ignored"})
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	changes, err := a.applyJiraEvent(r.Context(), &issue, event)
+	if err != nil {
+		a.Logger.ErrorContext(r.Context(), "unable to apply Jira webhook", "event", event.WebhookEvent, "issue_jira_id", issue.IssueJiraID, "error", err.Error())
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if a.Sync != nil {
+		a.Sync.Registry.succeeded(issue, time.Now())
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{"result": "processed", "changes": changes})
+}
+
+// applyJiraEvent updates issue from a webhook event, appending a step_log
+// entry for every status transition and comment, and returns how many
+// changes it recorded. Transitions to the status issue already has are
+// skipped.
+func (a *App) applyJiraEvent(ctx context.Context, issue *Issues, event jiraWebhookEvent) (int, error) {
+	changes := 0
+	transition := func(status, description, actor string) error {
+		if err := a.IssueRepo.UpdateStatus(ctx, issue, StatusChange{
+			Status:        status,
+			ReporterName:  "jira",
+			SupporterName: actor,
+			Description:   description,
+		}); err != nil {
+			return err
+		}
+		changes++
+		return nil
+	}
+
+	switch event.WebhookEvent {
+	case "jira:issue_updated":
+		for _, item := range event.Changelog.Items {
+			// The sync worker may have got there first, or Jira may deliver
+			// the event again; neither is a new transition.
+			if item.Field != "status" || strings.EqualFold(item.ToString, issue.Status) {
+				continue
+			}
+			description := fmt.Sprintf("Status changed from %s to %s", item.FromString, item.ToString)
+			if err := transition(item.ToString, description, event.User.String()); err != nil {
+				return changes, err
+			}
+		}
+		// Catch up if an earlier event was missed and the changelog does not
+		// explain the current status.
+		current := event.Issue.Fields.Status.Name
+		if current != "" && !strings.EqualFold(current, issue.Status) {
+			description := fmt.Sprintf("Status changed from %s to %s", issue.Status, current)
+			if err := transition(current, description, event.User.String()); err != nil {
+				return changes, err
+			}
+		}
+	case "jira:issue_deleted":
+		if err := transition("DELETED", "Issue deleted in Jira", event.User.String()); err != nil {
+			return changes, err
+		}
+	case "comment_created":
+		if err := a.StepLogRepo.Add(ctx, &StepLog{
+			IssueID:       issue.IssueJiraID,
+			ReporterName:  "jira",
+			SupporterName: event.Comment.Author.String(),
+			Description:   event.Comment.Body,
+			Status:        issue.Status,
+		}); err != nil {
+			return changes, err
+		}
+		changes++
+	}
+	return changes, nil
+}